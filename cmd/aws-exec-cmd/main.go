@@ -11,12 +11,19 @@
 //   AWS_ACCESS_KEY_ID
 //   AWS_SECRET_ACCESS_KEY
 //   AWS_SESSION_TOKEN
+//   AWS_CREDENTIAL_EXPIRATION (if known)
+//   AWS_REGION, AWS_DEFAULT_REGION (if --region is used)
+//   AWS_EXEC_CMD_ACCOUNT_ID, AWS_EXEC_CMD_ROLE_ARN, AWS_EXEC_CMD_ROLE_SESSION_NAME (if known)
+//   AWS_EXEC_CMD_ROLE_CHAIN (role mode only)
+//
+// Rename them with --env-name OLD=NEW or --env-prefix PREFIX.
 //
 // Usage:
 //
 //   aws-exec-cmd --help
 //   aws-exec-cmd role --help
 //   aws-exec-cmd idp --help
+//   aws-exec-cmd unset --help
 //
 // Use the IAM role, attached to an EC2 instance, to run "env | grep AWS_":
 //
@@ -39,6 +46,11 @@
 //     --client-id <Google OAuth client ID> \
 //     --client-secret <Google OAuth client secret>
 //
+// Print the credentials as shell statements instead of running a command, and clear them later:
+//
+//   eval "$(aws-exec-cmd role --chain instance,arn:aws:iam::123456789012:role/backup --print sh)"
+//   eval "$(aws-exec-cmd unset --format sh)"
+//
 // Supported AssumeRole chaining:
 //
 //   environment variable credentials -> AssumeRole [-> AssumeRole ...]
@@ -52,6 +64,7 @@ import (
 	"github.com/codeactual/aws-exec-cmd/idp"
 	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler"
 	"github.com/codeactual/aws-exec-cmd/role"
+	"github.com/codeactual/aws-exec-cmd/unset"
 )
 
 func main() {
@@ -63,6 +76,7 @@ func main() {
 	rootCmd.Version = handler.Version()
 	rootCmd.AddCommand(role.NewCommand())
 	rootCmd.AddCommand(idp.NewCommand())
+	rootCmd.AddCommand(unset.NewCommand())
 
 	if err := rootCmd.Execute(); err != nil {
 		panic(errors.WithStack(err))
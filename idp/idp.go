@@ -58,9 +58,7 @@ func (h *Handler) BindFlags(cmd *cobra.Command) []string {
 //
 // It implements cli/handler/cobra.Handler.
 func (h *Handler) Run(ctx context.Context, input handler.Input) {
-	creds, credsErr := h.Auth.Credentials(&h.IdentityPool)
-	h.ExitOnErr(credsErr, "failed to acquire credentials", 1)
-	h.Exec.Do(ctx, creds, input.Args)
+	h.Exec.Do(ctx, &h.Auth, &h.IdentityPool, input.Args)
 }
 
 // New returns a cobra command instance based on Handler.
@@ -11,6 +11,8 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/pkg/errors"
@@ -19,33 +21,152 @@ import (
 )
 
 func GetenvRegion() string {
-	r := os.Getenv("AWS_REGION")
+	r := os.Getenv(RegionEnv)
 	if r != "" {
 		return r
 	}
-	return os.Getenv("AWS_DEFAULT_REGION")
+	return os.Getenv(DefaultRegionEnv)
+}
+
+// Names of the environment variables which select the region.
+//
+// SDKs differ in which one they read, so both are typically set.
+const (
+	RegionEnv        = "AWS_REGION"
+	DefaultRegionEnv = "AWS_DEFAULT_REGION"
+)
+
+// CredentialExpirationEnv is the name of the environment variable which holds the credentials'
+// expiration in RFC 3339 format, as read by some SDKs and tools to decide when to refresh.
+const CredentialExpirationEnv = "AWS_CREDENTIAL_EXPIRATION"
+
+// Names of the environment variables which hold the credentials triple.
+//
+// Based on https://docs.aws.amazon.com/cli/latest/userguide/cli-environment.html
+const (
+	AccessKeyIDEnv     = "AWS_ACCESS_KEY_ID"
+	SecretAccessKeyEnv = "AWS_SECRET_ACCESS_KEY"
+	SessionTokenEnv    = "AWS_SESSION_TOKEN"
+)
+
+// CredentialsEnv returns "KEY=value" pairs which provide the credentials to a command.
+//
+// CredentialExpirationEnv is included if the credentials' provider exposes the expiration.
+func CredentialsEnv(creds *credentials.Credentials) ([]string, error) {
+	credsVal, credsErr := creds.Get()
+	if credsErr != nil {
+		return nil, errors.WithStack(credsErr)
+	}
+
+	env := []string{
+		AccessKeyIDEnv + "=" + credsVal.AccessKeyID,
+		SecretAccessKeyEnv + "=" + credsVal.SecretAccessKey,
+		SessionTokenEnv + "=" + credsVal.SessionToken,
+	}
+
+	if expiration, err := creds.ExpiresAt(); err == nil && !expiration.IsZero() {
+		env = append(env, CredentialExpirationEnv+"="+expiration.UTC().Format(time.RFC3339))
+	}
+
+	return env, nil
+}
+
+// ConflictingEnv holds variables which select, or provide, credentials other than those
+// in the variables set by CredentialsEnv.
+//
+// Depending on the SDK and its version, an inherited variable such as AWS_PROFILE or
+// a container credentials URI can take precedence over the provided credentials.
+var ConflictingEnv = []string{
+	AccessKeyIDEnv,
+	SecretAccessKeyEnv,
+	SessionTokenEnv,
+	CredentialExpirationEnv,
+	"AWS_ACCESS_KEY",     // legacy name read by some SDKs
+	"AWS_SECRET_KEY",     // legacy name read by some SDKs
+	"AWS_SECURITY_TOKEN", // legacy name read by boto
+	"AWS_PROFILE",
+	"AWS_DEFAULT_PROFILE",
+	"AWS_SHARED_CREDENTIALS_FILE",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+	"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+	"AWS_WEB_IDENTITY_TOKEN_FILE",
+	"AWS_ROLE_ARN",
+	"AWS_ROLE_SESSION_NAME",
+}
+
+// ScrubEnv returns a copy of the "KEY=value" pairs without ConflictingEnv variables,
+// except those in keep.
+func ScrubEnv(env []string, keep ...string) []string {
+	omit := make(map[string]bool, len(ConflictingEnv))
+	for _, name := range ConflictingEnv {
+		omit[name] = true
+	}
+	for _, name := range keep {
+		delete(omit, name)
+	}
+
+	scrubbed := []string{}
+	for _, pair := range env {
+		if !omit[strings.SplitN(pair, "=", 2)[0]] {
+			scrubbed = append(scrubbed, pair)
+		}
+	}
+
+	return scrubbed
 }
 
 // ExecAs executes a local command with AWS credentials defined in the environment.
 //
-// If a pseudo-terminal is used, a zero value PipelineResult will be returned.
+// ConflictingEnv variables are not inherited from the current process.
 func ExecAs(ctx context.Context, creds *credentials.Credentials, out io.Writer, err io.Writer, in io.Reader, cmd *exec.Cmd, pty bool) (cage_exec.PipelineResult, error) {
-	credsVal, credsErr := creds.Get()
+	credsEnv, credsErr := CredentialsEnv(creds)
 	if credsErr != nil {
 		return cage_exec.PipelineResult{}, errors.WithStack(credsErr)
 	}
 
-	// Based on https://docs.aws.amazon.com/cli/latest/userguide/cli-environment.html
-	cmd.Env = append(
-		os.Environ(),
-		"AWS_ACCESS_KEY_ID="+credsVal.AccessKeyID,
-		"AWS_SECRET_ACCESS_KEY="+credsVal.SecretAccessKey,
-		"AWS_SESSION_TOKEN="+credsVal.SessionToken,
-	)
+	return ExecWithEnv(ctx, append(ScrubEnv(os.Environ()), credsEnv...), out, err, in, nil, nil, cmd, pty)
+}
+
+// ExecWithEnv executes a local command with the complete environment, e.g. one which
+// provides credentials in a form other than the variables set by ExecAs.
+//
+// Terminating signals received by the current process are forwarded to the command's process group.
+// Once the context is done, the group receives the stop sequence, or cage/os/exec.DefaultStopSignals if it is empty.
+//
+// If started is not nil, it is called once the command has started and receives the forwarded signals.
+func ExecWithEnv(ctx context.Context, env []string, out io.Writer, err io.Writer, in io.Reader, stop []cage_exec.StopSignal, started func(), cmd *exec.Cmd, pty bool) (cage_exec.PipelineResult, error) {
+	cmd.Env = env
+
+	executor := cage_exec.CommonExecutor{ForwardSignals: cage_exec.TerminatingSignals, StopSignals: stop, Started: onStarted(started)}
 
 	if pty {
-		return cage_exec.PipelineResult{}, cage_exec.CommonExecutor{}.Pty(cmd)
+		return executor.Pty(ctx, cmd)
+	}
+
+	return executor.Standard(ctx, out, err, in, cmd)
+}
+
+// ExecPipelineWithEnv executes a pipeline of local commands, without a shell, which all
+// receive the complete environment.
+//
+// Terminating signals received by the current process are forwarded to the process group of each command,
+// and the stop sequence and started function are handled like ExecWithEnv. The function is called
+// after each command starts.
+func ExecPipelineWithEnv(ctx context.Context, env []string, out io.Writer, err io.Writer, in io.Reader, stop []cage_exec.StopSignal, started func(), cmds ...*exec.Cmd) (cage_exec.PipelineResult, error) {
+	for _, cmd := range cmds {
+		cmd.Env = env
 	}
 
-	return cage_exec.CommonExecutor{}.Standard(ctx, out, err, in, cmd)
+	executor := cage_exec.CommonExecutor{ForwardSignals: cage_exec.TerminatingSignals, StopSignals: stop, Started: onStarted(started)}
+	return executor.Standard(ctx, out, err, in, cmds...)
+}
+
+// onStarted adapts the function, if not nil, to cage/os/exec.CommonExecutor.Started.
+func onStarted(started func()) func(*exec.Cmd) {
+	if started == nil {
+		return nil
+	}
+	return func(*exec.Cmd) { started() }
 }
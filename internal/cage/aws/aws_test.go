@@ -0,0 +1,61 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package aws_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/require"
+
+	cage_aws "github.com/codeactual/aws-exec-cmd/internal/cage/aws"
+	testecho "github.com/codeactual/aws-exec-cmd/internal/cage/cmd/testecho"
+)
+
+func TestScrubEnv(t *testing.T) {
+	env := []string{"PATH=/bin", "AWS_PROFILE=dev", "AWS_REGION=us-west-2", "AWS_ROLE_ARN=arn"}
+	require.Exactly(t, []string{"PATH=/bin", "AWS_REGION=us-west-2"}, cage_aws.ScrubEnv(env))
+	require.Exactly(t, []string{"PATH=/bin", "AWS_PROFILE=dev", "AWS_REGION=us-west-2"}, cage_aws.ScrubEnv(env, "AWS_PROFILE"))
+}
+
+func TestExecAs(t *testing.T) {
+	for name, value := range map[string]string{
+		"AWS_PROFILE":                        "dev",
+		"AWS_SHARED_CREDENTIALS_FILE":        "/some/file",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI": "http://127.0.0.1/creds",
+	} {
+		prev, ok := os.LookupEnv(name)
+		require.NoError(t, os.Setenv(name, value))
+		if ok {
+			defer os.Setenv(name, prev)
+		} else {
+			defer os.Unsetenv(name)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	creds := credentials.NewStaticCredentials("id", "secret", "token")
+
+	// testecho prints the environment it receives.
+	cmd := exec.Command("sh", "-c", `exec "$0" --stdout "$(env)"`, testecho.Which()) // #nosec
+
+	_, err := cage_aws.ExecAs(context.Background(), creds, &stdout, &stderr, nil, cmd, false)
+	require.NoError(t, err)
+
+	received := strings.Split(stdout.String(), "\n")
+	require.Contains(t, received, "AWS_ACCESS_KEY_ID=id")
+	require.Contains(t, received, "AWS_SECRET_ACCESS_KEY=secret")
+	require.Contains(t, received, "AWS_SESSION_TOKEN=token")
+	for _, pair := range received {
+		require.NotRegexp(t, "^(AWS_PROFILE|AWS_SHARED_CREDENTIALS_FILE|AWS_CONTAINER_CREDENTIALS_FULL_URI)=", pair)
+	}
+}
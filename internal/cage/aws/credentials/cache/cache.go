@@ -10,14 +10,31 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
+const (
+	// BackendFile selects Store, which saves one file per key in a directory.
+	BackendFile = "file"
+
+	// BackendKeyring selects Keyring, which saves entries in a kernel keyring (Linux only).
+	BackendKeyring = "keyring"
+
+	// BackendMemory selects Memory, which keeps entries only for the life of the process.
+	BackendMemory = "memory"
+
+	// DefaultKeyringName is the keyring used by NewBackend for BackendKeyring.
+	DefaultKeyringName = "cage-aws-cache"
+)
+
 // Key contents are used to build the filename where the value is read from or written to.
 //
 // All characters are allowed in the string values. The final key will be a filename-safe hash.
@@ -41,10 +58,69 @@ type Value struct {
 	SecretAccessKey string
 	SessionToken    string
 	Expires         int64
+
+	// ExpiresUnknown is true if the credentials' source did not report when they expire,
+	// in which case Expires only limits the lifetime of the entry.
+	ExpiresUnknown bool `json:",omitempty"`
+
+	// Arn identifies the principal of the credentials, if known, e.g. the assumed-role
+	// session ARN "arn:aws:sts::123456789012:assumed-role/name/session".
+	Arn string `json:",omitempty"`
+
+	// Data holds the content of entries which are not AWS credentials, e.g. an identity
+	// provider's ID token.
+	Data string `json:",omitempty"`
+}
+
+// Backend defines the storage operations required by cache consumers.
+//
+// Implementations must treat a cache miss, including an expired value, as a zero Value
+// and a nil error from Read.
+type Backend interface {
+	// Read returns the credentials triple if found by the given key.
+	Read(Key) (Value, error)
+
+	// Write saves the credentials triple under the given key.
+	Write(Key, Value) error
+
+	// Delete removes the value, if any, stored under the given key.
+	Delete(Key) error
+
+	// List returns the keys of all stored values sorted by Key.String.
+	//
+	// Expired values are included until they are replaced or deleted, unless the backend's
+	// storage removes them on its own, e.g. Keyring.
+	List() ([]Key, error)
+}
+
+// NewBackend returns the Backend implementation selected by name, e.g. BackendFile.
+//
+// The dir is only used by backends which store values in the file system.
+func NewBackend(name, dir string) (Backend, error) {
+	switch name {
+	case "", BackendFile:
+		return NewStore(dir), nil
+	case BackendKeyring:
+		return NewKeyring(DefaultKeyringName)
+	case BackendMemory:
+		return NewMemory(), nil
+	}
+	return nil, errors.Errorf("cache backend [%s] is not recognized", name)
+}
+
+// entry is the JSON structure of each Store file.
+//
+// Value is embedded to remain compatible with files written before Key was recorded.
+type entry struct {
+	Key Key
+	Value
 }
 
 type Store struct {
 	Dir string
+
+	// Err receives warnings about files which List skips. If nil, os.Stderr is used.
+	Err io.Writer
 }
 
 func NewStore(dir string) Store {
@@ -54,12 +130,13 @@ func NewStore(dir string) Store {
 // Write saves the credentials triple if found by the given key.
 //
 // If the cache dir does not exist, it will be created.
+// Write implements Backend.
 func (s Store) Write(k Key, out Value) error {
 	if dirErr := s.mkdir(); dirErr != nil {
 		return errors.WithStack(dirErr)
 	}
 
-	buf, jsonErr := json.Marshal(out)
+	buf, jsonErr := json.Marshal(entry{Key: k, Value: out})
 	if jsonErr != nil {
 		return errors.WithStack(jsonErr)
 	}
@@ -77,31 +154,101 @@ func (s Store) Write(k Key, out Value) error {
 // Callers will receive three empty strings and a nil error on a cache miss.
 // An error is returned only if the read operation failed.
 // If the cache dir does not exist, it will be created.
+// Read implements Backend.
 func (s Store) Read(k Key) (v Value, err error) {
 	if dirErr := s.mkdir(); dirErr != nil {
 		return Value{}, errors.WithStack(dirErr)
 	}
 
+	e, readErr := s.readFile(s.filename(k))
+	if readErr != nil {
+		if os.IsNotExist(errors.Cause(readErr)) {
+			return Value{}, nil // don't treat cache miss as an error
+		}
+		return Value{}, errors.WithStack(readErr)
+	}
+
+	if e.Value.live() {
+		return e.Value, nil
+	}
+
+	// No need to remove the stale file. Let the next successful Write operation replace it.
+
+	return Value{}, nil
+}
+
+// Delete removes the file, if any, stored under the given key.
+//
+// Delete implements Backend.
+func (s Store) Delete(k Key) error {
 	filename := s.filename(k)
-	buf, readErr := ioutil.ReadFile(filename) // #nosec G304
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove cache file [%s]", filename)
+	}
+	return nil
+}
+
+// List returns the keys of all files in the cache dir.
+//
+// Files written before keys were recorded in the file content are omitted, as are files
+// which cannot be read or parsed, e.g. ones still being written by another process.
+// The latter are reported to Err instead of failing the whole List.
+//
+// List implements Backend.
+func (s Store) List() (keys []Key, err error) {
+	infos, readErr := ioutil.ReadDir(s.Dir)
 	if readErr != nil {
 		if os.IsNotExist(readErr) {
-			return Value{}, nil // don't treat cache miss as an error
+			return []Key{}, nil
+		}
+		return nil, errors.Wrapf(readErr, "failed to read cache dir [%s]", s.Dir)
+	}
+
+	keys = []Key{}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+
+		e, fileErr := s.readFile(filepath.Join(s.Dir, info.Name()))
+		if fileErr != nil {
+			if !os.IsNotExist(errors.Cause(fileErr)) { // removed since ReadDir
+				s.warnf("skipped cache file: %v\n", fileErr)
+			}
+			continue
+		}
+
+		if e.Key == (Key{}) {
+			continue
 		}
-		return Value{}, errors.Wrapf(readErr, "failed to read cache file [%s]", filename)
+
+		keys = append(keys, e.Key)
 	}
 
-	if jsonErr := json.Unmarshal(buf, &v); jsonErr != nil {
-		return Value{}, errors.WithStack(jsonErr)
+	sortKeys(keys)
+
+	return keys, nil
+}
+
+func (s Store) readFile(filename string) (e entry, err error) {
+	buf, readErr := ioutil.ReadFile(filename) // #nosec G304
+	if readErr != nil {
+		return entry{}, errors.Wrapf(readErr, "failed to read cache file [%s]", filename)
 	}
 
-	if v.Expires-time.Now().Unix() > 0 {
-		return v, nil
+	if jsonErr := json.Unmarshal(buf, &e); jsonErr != nil {
+		return entry{}, errors.Wrapf(jsonErr, "failed to parse cache file [%s]", filename)
 	}
 
-	// No need to remove the stale file. Let the next successful Write operation replace it.
+	return e, nil
+}
 
-	return Value{}, nil
+func (s Store) warnf(format string, a ...interface{}) {
+	w := s.Err
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format, a...)
 }
 
 func (s Store) filename(k Key) string {
@@ -111,3 +258,16 @@ func (s Store) filename(k Key) string {
 func (s Store) mkdir() (err error) {
 	return os.MkdirAll(s.Dir, 0700)
 }
+
+// live returns true if the value has not expired.
+func (v Value) live() bool {
+	return v.Expires-time.Now().Unix() > 0
+}
+
+func sortKeys(keys []Key) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+}
+
+var _ Backend = Store{}
@@ -0,0 +1,155 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cache_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/cache"
+)
+
+func newValue(ttl time.Duration) cache.Value {
+	return cache.Value{
+		AccessKeyID:     "id",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expires:         time.Now().Add(ttl).Unix(),
+	}
+}
+
+// requireBackend asserts the behavior shared by all backends.
+//
+// If expiredListed is false, the backend's storage removes expired values on its own,
+// so List is expected to omit them once that happens.
+func requireBackend(t *testing.T, b cache.Backend, expiredListed bool) {
+	k0 := cache.Key{MfaSerial: "serial", Role: "instance"}
+	k1 := cache.Key{Role: "env-triple,arn:aws:iam::123456789012:role/backup"}
+
+	keys, err := b.List()
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	v, err := b.Read(k0)
+	require.NoError(t, err)
+	require.Exactly(t, cache.Value{}, v)
+
+	live := newValue(time.Hour)
+	require.NoError(t, b.Write(k0, live))
+
+	v, err = b.Read(k0)
+	require.NoError(t, err)
+	require.Exactly(t, live, v)
+
+	// expired values are a miss, and listed only by backends which keep them
+
+	require.NoError(t, b.Write(k1, newValue(-time.Hour)))
+
+	v, err = b.Read(k1)
+	require.NoError(t, err)
+	require.Exactly(t, cache.Value{}, v)
+
+	listed := []cache.Key{}
+	if expiredListed {
+		listed = []cache.Key{k1}
+
+		keys, err = b.List()
+		require.NoError(t, err)
+		require.ElementsMatch(t, []cache.Key{k0, k1}, keys)
+	} else {
+		require.Eventually(t, func() bool {
+			keys, err = b.List()
+			require.NoError(t, err)
+			return len(keys) == 1
+		}, 5*time.Second, 100*time.Millisecond)
+		require.Exactly(t, []cache.Key{k0}, keys)
+	}
+
+	require.NoError(t, b.Delete(k0))
+	require.NoError(t, b.Delete(k0)) // missing key is not an error
+
+	v, err = b.Read(k0)
+	require.NoError(t, err)
+	require.Exactly(t, cache.Value{}, v)
+
+	keys, err = b.List()
+	require.NoError(t, err)
+	require.Exactly(t, listed, keys)
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cage-aws-cache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	requireBackend(t, cache.NewStore(filepath.Join(dir, "store")), true)
+}
+
+func TestStoreLegacyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cage-aws-cache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	k := cache.Key{Role: "instance"}
+	expires := time.Now().Add(time.Hour).Unix()
+	s := cache.NewStore(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, k.String()), []byte(
+		`{"AccessKeyID":"id","SecretAccessKey":"secret","SessionToken":"token","Expires":`+strconv.FormatInt(expires, 10)+`}`,
+	), 0600))
+
+	v, err := s.Read(k)
+	require.NoError(t, err)
+	require.Exactly(t, "id", v.AccessKeyID)
+	require.Exactly(t, expires, v.Expires)
+
+	keys, err := s.List()
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func TestStoreUnparseableFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cage-aws-cache-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var stderr bytes.Buffer
+	s := cache.NewStore(dir)
+	s.Err = &stderr
+
+	k := cache.Key{Role: "instance"}
+	require.NoError(t, s.Write(k, newValue(time.Hour)))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "partial"), []byte(`{"Key":`), 0600))
+
+	keys, err := s.List()
+	require.NoError(t, err)
+	require.Exactly(t, []cache.Key{k}, keys)
+	require.Contains(t, stderr.String(), "skipped cache file: failed to parse cache file ["+filepath.Join(dir, "partial")+"]")
+}
+
+func TestMemory(t *testing.T) {
+	requireBackend(t, cache.NewMemory(), true)
+}
+
+func TestNewBackend(t *testing.T) {
+	b, err := cache.NewBackend(cache.BackendFile, "/some/dir")
+	require.NoError(t, err)
+	require.Exactly(t, cache.NewStore("/some/dir"), b)
+
+	b, err = cache.NewBackend(cache.BackendMemory, "")
+	require.NoError(t, err)
+	require.IsType(t, &cache.Memory{}, b)
+
+	_, err = cache.NewBackend("other", "")
+	require.EqualError(t, err, "cache backend [other] is not recognized")
+}
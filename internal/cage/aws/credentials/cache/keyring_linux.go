@@ -0,0 +1,232 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cache
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// keyType is the kernel key type used for entries. Its payload is opaque to the kernel.
+	keyType = "user"
+
+	// keyDescPrefix namespaces entry descriptions so List can ignore unrelated keys.
+	keyDescPrefix = "cage-aws-cache:"
+)
+
+// Keyring stores values in a kernel keyring, linked into the user keyring, instead of on disk.
+//
+// Each entry's kernel timeout is set from Value.Expires, so the kernel removes it
+// once the credentials expire. As a result, List omits expired entries, except for
+// up to a second after an already-expired value is written (the minimum timeout).
+type Keyring struct {
+	// Name is the description of the keyring which holds all entries.
+	Name string
+
+	// ID is the serial number of the keyring.
+	ID int
+}
+
+// NewKeyring returns a Keyring backed by the named keyring, which is created in
+// the user keyring if it does not already exist.
+func NewKeyring(name string) (*Keyring, error) {
+	id, searchErr := unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, "keyring", name, 0)
+	if searchErr != nil {
+		if searchErr != unix.ENOKEY {
+			return nil, errors.Wrapf(searchErr, "failed to search user keyring for [%s]", name)
+		}
+
+		var addErr error
+		id, addErr = unix.AddKey("keyring", name, nil, unix.KEY_SPEC_USER_KEYRING)
+		if addErr != nil {
+			return nil, errors.Wrapf(addErr, "failed to add keyring [%s] to user keyring", name)
+		}
+	}
+
+	return &Keyring{Name: name, ID: id}, nil
+}
+
+// Read implements Backend.
+func (r *Keyring) Read(k Key) (Value, error) {
+	id, searchErr := unix.KeyctlSearch(r.ID, keyType, keyDescPrefix+k.String(), 0)
+	if searchErr != nil {
+		if isKeyMiss(searchErr) {
+			return Value{}, nil
+		}
+		return Value{}, errors.Wrapf(searchErr, "failed to search keyring [%s] for key [%s]", r.Name, k)
+	}
+
+	e, readErr := r.readEntry(id)
+	if readErr != nil {
+		if isKeyMiss(errors.Cause(readErr)) {
+			return Value{}, nil
+		}
+		return Value{}, errors.WithStack(readErr)
+	}
+
+	if e.Value.live() {
+		return e.Value, nil
+	}
+
+	return Value{}, nil
+}
+
+// Write implements Backend.
+//
+// An existing entry with the same key is updated in place.
+func (r *Keyring) Write(k Key, v Value) error {
+	buf, jsonErr := json.Marshal(entry{Key: k, Value: v})
+	if jsonErr != nil {
+		return errors.WithStack(jsonErr)
+	}
+
+	id, addErr := unix.AddKey(keyType, keyDescPrefix+k.String(), buf, r.ID)
+	if addErr != nil {
+		return errors.Wrapf(addErr, "failed to add key [%s] to keyring [%s]", k, r.Name)
+	}
+
+	// The kernel treats 0 as "no timeout," so already-expired values are given the minimum.
+	timeout := v.Expires - time.Now().Unix()
+	if timeout < 1 {
+		timeout = 1
+	}
+
+	if _, timeoutErr := unix.KeyctlInt(unix.KEYCTL_SET_TIMEOUT, id, int(timeout), 0, 0); timeoutErr != nil {
+		return errors.Wrapf(timeoutErr, "failed to set timeout of key [%s] in keyring [%s]", k, r.Name)
+	}
+
+	return nil
+}
+
+// Delete implements Backend.
+func (r *Keyring) Delete(k Key) error {
+	id, searchErr := unix.KeyctlSearch(r.ID, keyType, keyDescPrefix+k.String(), 0)
+	if searchErr != nil {
+		if isKeyMiss(searchErr) {
+			return nil
+		}
+		return errors.Wrapf(searchErr, "failed to search keyring [%s] for key [%s]", r.Name, k)
+	}
+
+	if _, unlinkErr := unix.KeyctlInt(unix.KEYCTL_UNLINK, id, r.ID, 0, 0); unlinkErr != nil && !isKeyMiss(unlinkErr) {
+		return errors.Wrapf(unlinkErr, "failed to unlink key [%s] from keyring [%s]", k, r.Name)
+	}
+
+	return nil
+}
+
+// List implements Backend.
+func (r *Keyring) List() ([]Key, error) {
+	ids, idsErr := r.readIDs()
+	if idsErr != nil {
+		return nil, errors.WithStack(idsErr)
+	}
+
+	keys := []Key{}
+	for _, id := range ids {
+		desc, descErr := unix.KeyctlString(unix.KEYCTL_DESCRIBE, id)
+		if descErr != nil {
+			if isKeyMiss(descErr) {
+				continue
+			}
+			return nil, errors.Wrapf(descErr, "failed to describe key [%d] in keyring [%s]", id, r.Name)
+		}
+
+		// Format: "type;uid;gid;perm;description"
+		descParts := strings.SplitN(desc, ";", 5)
+		if len(descParts) != 5 || descParts[0] != keyType || !strings.HasPrefix(descParts[4], keyDescPrefix) {
+			continue
+		}
+
+		e, readErr := r.readEntry(id)
+		if readErr != nil {
+			if isKeyMiss(errors.Cause(readErr)) {
+				continue
+			}
+			return nil, errors.WithStack(readErr)
+		}
+
+		keys = append(keys, e.Key)
+	}
+
+	sortKeys(keys)
+
+	return keys, nil
+}
+
+// Unlink removes the keyring from the user keyring, which also releases all entries.
+func (r *Keyring) Unlink() error {
+	if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, r.ID, unix.KEY_SPEC_USER_KEYRING, 0, 0); err != nil && !isKeyMiss(err) {
+		return errors.Wrapf(err, "failed to unlink keyring [%s] from user keyring", r.Name)
+	}
+	return nil
+}
+
+// readEntry returns the parsed payload of the key.
+func (r *Keyring) readEntry(id int) (e entry, err error) {
+	buf, readErr := keyctlRead(id)
+	if readErr != nil {
+		return entry{}, errors.Wrapf(readErr, "failed to read key [%d] in keyring [%s]", id, r.Name)
+	}
+
+	if jsonErr := json.Unmarshal(buf, &e); jsonErr != nil {
+		return entry{}, errors.Wrapf(jsonErr, "failed to parse key [%d] in keyring [%s]", id, r.Name)
+	}
+
+	return e, nil
+}
+
+// readIDs returns the serial numbers of all keys linked into the keyring.
+func (r *Keyring) readIDs() (ids []int, err error) {
+	buf, readErr := keyctlRead(r.ID)
+	if readErr != nil {
+		return nil, errors.Wrapf(readErr, "failed to read keyring [%s]", r.Name)
+	}
+
+	// The payload of a keyring is an array of 32-bit serial numbers.
+	for n := 0; n+4 <= len(buf); n += 4 {
+		ids = append(ids, int(int32(binary.LittleEndian.Uint32(buf[n:n+4]))))
+	}
+
+	return ids, nil
+}
+
+// keyctlRead returns the full payload of the key.
+//
+// KEYCTL_READ returns the payload size regardless of the buffer size, so the first call
+// only measures it. The loop handles a payload which grows between calls.
+func keyctlRead(id int) ([]byte, error) {
+	size, sizeErr := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if sizeErr != nil {
+		return nil, errors.WithStack(sizeErr)
+	}
+
+	for {
+		buf := make([]byte, size)
+		n, readErr := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+		if readErr != nil {
+			return nil, errors.WithStack(readErr)
+		}
+		if n <= size {
+			return buf[:n], nil
+		}
+		size = n
+	}
+}
+
+// isKeyMiss returns true if the error indicates the key is absent or no longer usable.
+func isKeyMiss(err error) bool {
+	return err == unix.ENOKEY || err == unix.EKEYEXPIRED || err == unix.EKEYREVOKED
+}
+
+var _ Backend = (*Keyring)(nil)
@@ -0,0 +1,37 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cache_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/cache"
+	cage_crypto "github.com/codeactual/aws-exec-cmd/internal/cage/crypto"
+)
+
+func TestKeyring(t *testing.T) {
+	suffix, err := cage_crypto.RandHexString(4)
+	require.NoError(t, err)
+
+	k, err := cache.NewKeyring("cage-aws-cache-test-" + suffix)
+	if err != nil {
+		t.Skipf("kernel keyring unavailable: %+v", err)
+	}
+	defer func() {
+		require.NoError(t, k.Unlink())
+	}()
+
+	requireBackend(t, k, false)
+
+	// reopening finds the existing keyring
+
+	reopened, err := cache.NewKeyring(k.Name)
+	require.NoError(t, err)
+	require.Exactly(t, k.ID, reopened.ID)
+}
@@ -0,0 +1,52 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build !linux
+// +build !linux
+
+package cache
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Keyring is only supported on Linux.
+type Keyring struct {
+	Name string
+	ID   int
+}
+
+// NewKeyring returns an error because kernel keyrings are only supported on Linux.
+func NewKeyring(name string) (*Keyring, error) {
+	return nil, errors.Errorf("keyring [%s] cache backend is only supported on linux", name)
+}
+
+// Read implements Backend.
+func (r *Keyring) Read(k Key) (Value, error) {
+	return Value{}, errors.New("keyring cache backend is only supported on linux")
+}
+
+// Write implements Backend.
+func (r *Keyring) Write(k Key, v Value) error {
+	return errors.New("keyring cache backend is only supported on linux")
+}
+
+// Delete implements Backend.
+func (r *Keyring) Delete(k Key) error {
+	return errors.New("keyring cache backend is only supported on linux")
+}
+
+// List implements Backend.
+func (r *Keyring) List() ([]Key, error) {
+	return nil, errors.New("keyring cache backend is only supported on linux")
+}
+
+// Unlink removes the keyring from the user keyring, which also releases all entries.
+func (r *Keyring) Unlink() error {
+	return errors.New("keyring cache backend is only supported on linux")
+}
+
+var _ Backend = (*Keyring)(nil)
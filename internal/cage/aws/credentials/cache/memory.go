@@ -0,0 +1,71 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cache
+
+import (
+	"sync"
+)
+
+// Memory stores values for the life of the process, e.g. for tests or programs which
+// embed the cache and do not want files written.
+//
+// It is safe for concurrent use.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]entry)}
+}
+
+// Read implements Backend.
+func (m *Memory) Read(k Key) (Value, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[k.String()]
+	if !ok || !e.Value.live() {
+		return Value{}, nil
+	}
+	return e.Value, nil
+}
+
+// Write implements Backend.
+func (m *Memory) Write(k Key, v Value) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[k.String()] = entry{Key: k, Value: v}
+	return nil
+}
+
+// Delete implements Backend.
+func (m *Memory) Delete(k Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, k.String())
+	return nil
+}
+
+// List implements Backend.
+func (m *Memory) List() ([]Key, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := []Key{}
+	for _, e := range m.entries {
+		keys = append(keys, e.Key)
+	}
+
+	sortKeys(keys)
+
+	return keys, nil
+}
+
+var _ Backend = (*Memory)(nil)
@@ -0,0 +1,70 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package expiring provides static credentials which also expose their expiration.
+//
+// The SDK's StaticProvider does not implement credentials.Expirer, so consumers of
+// temporary credentials cannot learn when they expire from a *credentials.Credentials.
+package expiring
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// ProviderName is the credentials.Value.ProviderName of values returned by Provider.
+const ProviderName = "ExpiringStaticProvider"
+
+// Provider returns a fixed value and implements credentials.Expirer.
+type Provider struct {
+	Value      credentials.Value
+	Expiration time.Time
+}
+
+// Retrieve implements credentials.Provider.
+func (p *Provider) Retrieve() (credentials.Value, error) {
+	v := p.Value
+	if v.ProviderName == "" {
+		v.ProviderName = ProviderName
+	}
+	return v, nil
+}
+
+// IsExpired implements credentials.Provider.
+func (p *Provider) IsExpired() bool {
+	return !time.Now().Before(p.Expiration)
+}
+
+// ExpiresAt implements credentials.Expirer.
+func (p *Provider) ExpiresAt() time.Time {
+	return p.Expiration
+}
+
+// NewCredentials returns credentials which will report the expiration from ExpiresAt.
+func NewCredentials(v credentials.Value, expiration time.Time) *credentials.Credentials {
+	return credentials.NewCredentials(&Provider{Value: v, Expiration: expiration})
+}
+
+// ExpiresAt returns the expiration of the credentials if their provider exposes it.
+//
+// It retrieves the value first, if needed, because the SDK only reports expiration
+// of a retrieved value.
+func ExpiresAt(creds *credentials.Credentials) (expiration time.Time, ok bool) {
+	if _, err := creds.Get(); err != nil {
+		return time.Time{}, false
+	}
+
+	expiration, err := creds.ExpiresAt()
+	if err != nil || expiration.IsZero() {
+		return time.Time{}, false
+	}
+
+	return expiration, true
+}
+
+var _ credentials.Provider = (*Provider)(nil)
+var _ credentials.Expirer = (*Provider)(nil)
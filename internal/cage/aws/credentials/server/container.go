@@ -0,0 +1,112 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ContainerFullURIEnv is the environment variable from which SDKs read the endpoint URL.
+	ContainerFullURIEnv = "AWS_CONTAINER_CREDENTIALS_FULL_URI"
+
+	// ContainerRelativeURIEnv is the environment variable from which SDKs read the endpoint path
+	// relative to the ECS agent. SDKs check it before ContainerFullURIEnv.
+	ContainerRelativeURIEnv = "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"
+
+	// ContainerAuthTokenEnv is the environment variable from which SDKs read the Authorization header value.
+	ContainerAuthTokenEnv = "AWS_CONTAINER_AUTHORIZATION_TOKEN"
+)
+
+// containerResponse is the JSON body expected by the SDKs' container/endpoint credentials providers.
+type containerResponse struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      string `json:",omitempty"`
+}
+
+// containerErrorResponse is the JSON body of a failed request.
+type containerErrorResponse struct {
+	Code    string
+	Message string
+}
+
+// ContainerHandler serves credentials using the ECS container credentials protocol.
+//
+// SDKs only accept a AWS_CONTAINER_CREDENTIALS_FULL_URI with a loopback host, so it
+// should be served on a localhost address.
+type ContainerHandler struct {
+	Refresher *Refresher
+
+	// AuthToken must equal the Authorization header of each request.
+	AuthToken string
+
+	// Err receives errors which cannot be reported to the client. If nil, os.Stderr is used.
+	Err io.Writer
+}
+
+// ServeHTTP implements http.Handler.
+func (h *ContainerHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		h.writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "only GET is supported")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(h.AuthToken)) != 1 {
+		h.writeError(w, http.StatusUnauthorized, "Unauthorized", "invalid authorization token")
+		return
+	}
+
+	value, expiration, err := h.Refresher.Get()
+	if err != nil {
+		h.logf("failed to serve container credentials: %+v\n", err)
+		h.writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	res := containerResponse{
+		AccessKeyId:     value.AccessKeyID,
+		SecretAccessKey: value.SecretAccessKey,
+		Token:           value.SessionToken,
+	}
+	if !expiration.IsZero() {
+		res.Expiration = expiration.UTC().Format(time.RFC3339)
+	}
+
+	h.writeJSON(w, http.StatusOK, res)
+}
+
+func (h *ContainerHandler) writeError(w http.ResponseWriter, status int, code, message string) {
+	h.writeJSON(w, status, containerErrorResponse{Code: code, Message: message})
+}
+
+func (h *ContainerHandler) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		h.logf("failed to write container credentials response: %+v\n", errors.WithStack(err))
+	}
+}
+
+func (h *ContainerHandler) logf(format string, a ...interface{}) {
+	w := h.Err
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format, a...)
+}
+
+var _ http.Handler = (*ContainerHandler)(nil)
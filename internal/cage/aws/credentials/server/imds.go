@@ -0,0 +1,206 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	cage_crypto "github.com/codeactual/aws-exec-cmd/internal/cage/crypto"
+)
+
+const (
+	// IMDSEndpointEnv is the environment variable from which SDKs read an alternate metadata service URL.
+	IMDSEndpointEnv = "AWS_EC2_METADATA_SERVICE_ENDPOINT"
+
+	// IMDSDisabledEnv is the environment variable which disables SDK use of the metadata service.
+	IMDSDisabledEnv = "AWS_EC2_METADATA_DISABLED"
+
+	// IMDSTokenPath is the IMDSv2 session token path.
+	IMDSTokenPath = "/latest/api/token"
+
+	// IMDSCredentialsPath is the path which lists the role name, and is the parent of the role's credentials path.
+	IMDSCredentialsPath = "/latest/meta-data/iam/security-credentials/"
+
+	imdsTokenHeader    = "X-aws-ec2-metadata-token"
+	imdsTokenTtlHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+
+	// imdsTokenMaxTtlSec is the maximum session token lifetime accepted by the real service.
+	imdsTokenMaxTtlSec = 21600
+
+	// imdsTokenBytes is the length of random session tokens before hex encoding.
+	imdsTokenBytes = 32
+)
+
+// imdsCredentialsResponse is the JSON body of the role credentials path.
+type imdsCredentialsResponse struct {
+	Code            string
+	LastUpdated     string
+	Type            string
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      string
+}
+
+// IMDSHandler serves credentials using the IMDSv1/v2 iam/security-credentials paths of the
+// EC2 instance metadata service.
+//
+// IMDSv2 session tokens are issued by PUT requests to IMDSTokenPath. A request which includes
+// a token must include a valid one. Requests without a token are rejected only if RequireToken
+// is enabled, i.e. IMDSv2 is "required" as in the instance metadata options.
+type IMDSHandler struct {
+	Refresher *Refresher
+
+	// RoleName is the instance profile role name listed by IMDSCredentialsPath.
+	RoleName string
+
+	// RequireToken rejects IMDSv1 requests.
+	RequireToken bool
+
+	// Err receives errors which cannot be reported to the client. If nil, os.Stderr is used.
+	Err io.Writer
+
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+// ServeHTTP implements http.Handler.
+func (h *IMDSHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == IMDSTokenPath {
+		h.serveToken(w, req)
+		return
+	}
+
+	if req.Method != http.MethodGet {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !h.authorized(req) {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	switch req.URL.Path {
+	case IMDSCredentialsPath, strings.TrimSuffix(IMDSCredentialsPath, "/"):
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, h.RoleName)
+	case IMDSCredentialsPath + h.RoleName, IMDSCredentialsPath + h.RoleName + "/":
+		h.serveCredentials(w)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// serveToken issues an IMDSv2 session token with the requested lifetime.
+func (h *IMDSHandler) serveToken(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The real service rejects token requests which were forwarded, e.g. from a container
+	// through a proxy, with this header.
+	if req.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "", http.StatusForbidden)
+		return
+	}
+
+	ttl, ttlErr := strconv.Atoi(req.Header.Get(imdsTokenTtlHeader))
+	if ttlErr != nil || ttl < 1 || ttl > imdsTokenMaxTtlSec {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	token, tokenErr := cage_crypto.RandHexString(imdsTokenBytes)
+	if tokenErr != nil {
+		h.logf("failed to generate metadata session token: %+v\n", errors.WithStack(tokenErr))
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	h.mu.Lock()
+	if h.tokens == nil {
+		h.tokens = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for t, expires := range h.tokens {
+		if now.After(expires) {
+			delete(h.tokens, t)
+		}
+	}
+	h.tokens[token] = now.Add(time.Duration(ttl) * time.Second)
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set(imdsTokenTtlHeader, strconv.Itoa(ttl))
+	fmt.Fprint(w, token)
+}
+
+// authorized returns true if the request has a valid session token or is allowed to omit one.
+func (h *IMDSHandler) authorized(req *http.Request) bool {
+	token := req.Header.Get(imdsTokenHeader)
+	if token == "" {
+		return !h.RequireToken
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	expires, ok := h.tokens[token]
+	return ok && time.Now().Before(expires)
+}
+
+func (h *IMDSHandler) serveCredentials(w http.ResponseWriter) {
+	value, expiration, err := h.Refresher.Get()
+	if err != nil {
+		h.logf("failed to serve metadata credentials: %+v\n", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	// SDKs require an expiration in this response, so report one far enough away that
+	// they will not refresh needlessly.
+	if expiration.IsZero() {
+		expiration = time.Now().Add(time.Hour)
+	}
+
+	res := imdsCredentialsResponse{
+		Code:            "Success",
+		LastUpdated:     time.Now().UTC().Format(time.RFC3339),
+		Type:            "AWS-HMAC",
+		AccessKeyId:     value.AccessKeyID,
+		SecretAccessKey: value.SecretAccessKey,
+		Token:           value.SessionToken,
+		Expiration:      expiration.UTC().Format(time.RFC3339),
+	}
+
+	w.Header().Set("Content-Type", "text/plain") // matches the real service
+	if encodeErr := json.NewEncoder(w).Encode(res); encodeErr != nil {
+		h.logf("failed to write metadata credentials response: %+v\n", errors.WithStack(encodeErr))
+	}
+}
+
+func (h *IMDSHandler) logf(format string, a ...interface{}) {
+	w := h.Err
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, format, a...)
+}
+
+var _ http.Handler = (*IMDSHandler)(nil)
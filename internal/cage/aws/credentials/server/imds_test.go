@@ -0,0 +1,111 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/server"
+)
+
+func TestIMDSHandler(t *testing.T) {
+	s := &source{ttl: time.Hour}
+	h := &server.IMDSHandler{
+		Refresher: server.NewRefresher(s.Get, 5*time.Minute),
+		RoleName:  "some-role",
+		Err:       ioutil.Discard,
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	get := func(t *testing.T, path, token string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("X-aws-ec2-metadata-token", token)
+		}
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("should serve credentials to the SDK", func(t *testing.T) {
+		sess := session.Must(session.NewSession(&aws.Config{
+			Endpoint:   aws.String(srv.URL + "/latest"),
+			HTTPClient: srv.Client(),
+		}))
+		creds := ec2rolecreds.NewCredentialsWithClient(ec2metadata.New(sess))
+
+		v, err := creds.Get()
+		require.NoError(t, err)
+		require.Exactly(t, "id1", v.AccessKeyID)
+		require.Exactly(t, "secret", v.SecretAccessKey)
+		require.Exactly(t, "token", v.SessionToken)
+		expiration, err := creds.ExpiresAt()
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(time.Hour), expiration, 5*time.Second)
+	})
+
+	t.Run("should list the role name", func(t *testing.T) {
+		res := get(t, server.IMDSCredentialsPath, "")
+		defer res.Body.Close()
+		require.Exactly(t, http.StatusOK, res.StatusCode)
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Exactly(t, "some-role", string(body))
+	})
+
+	t.Run("should reject an invalid token", func(t *testing.T) {
+		res := get(t, server.IMDSCredentialsPath+"some-role", "other")
+		defer res.Body.Close()
+		require.Exactly(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("should reject an invalid token TTL", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPut, srv.URL+server.IMDSTokenPath, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21601")
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Exactly(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("should reject an unknown role", func(t *testing.T) {
+		res := get(t, server.IMDSCredentialsPath+"other-role", "")
+		defer res.Body.Close()
+		require.Exactly(t, http.StatusNotFound, res.StatusCode)
+	})
+
+	t.Run("should require a token if configured", func(t *testing.T) {
+		h.RequireToken = true
+		defer func() { h.RequireToken = false }()
+
+		res := get(t, server.IMDSCredentialsPath+"some-role", "")
+		defer res.Body.Close()
+		require.Exactly(t, http.StatusUnauthorized, res.StatusCode)
+
+		// the SDK switches to IMDSv2 on its own
+		sess := session.Must(session.NewSession(&aws.Config{
+			Endpoint:   aws.String(srv.URL + "/latest"),
+			HTTPClient: srv.Client(),
+		}))
+		_, err := credentials.NewCredentials(&ec2rolecreds.EC2RoleProvider{Client: ec2metadata.New(sess)}).Get()
+		require.NoError(t, err)
+	})
+}
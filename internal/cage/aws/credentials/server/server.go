@@ -0,0 +1,73 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package server provides HTTP handlers which serve AWS credentials to SDKs over the
+// protocols they use to discover credentials from the environment, e.g. in containers.
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pkg/errors"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
+)
+
+// SourceFunc returns credentials which expire in at least minRemaining.
+type SourceFunc func(minRemaining time.Duration) (*credentials.Credentials, error)
+
+// Refresher holds the most recent credentials from a source and renews them shortly before they expire.
+//
+// It is safe for concurrent use.
+type Refresher struct {
+	// Source provides the initial and renewed credentials.
+	Source SourceFunc
+
+	// Before is how long before expiration the credentials are renewed.
+	Before time.Duration
+
+	mu         sync.Mutex
+	value      credentials.Value
+	expiration time.Time
+}
+
+// NewRefresher returns a Refresher which will renew credentials from the source
+// when they expire within the given duration.
+func NewRefresher(source SourceFunc, before time.Duration) *Refresher {
+	return &Refresher{Source: source, Before: before}
+}
+
+// Get returns the current credentials, first renewing them if they expire within Before.
+//
+// The returned expiration is the zero value if the source does not report one,
+// in which case the credentials are never renewed.
+func (r *Refresher) Get() (credentials.Value, time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.value.AccessKeyID != "" && (r.expiration.IsZero() || time.Until(r.expiration) >= r.Before) {
+		return r.value, r.expiration, nil
+	}
+
+	creds, sourceErr := r.Source(r.Before)
+	if sourceErr != nil {
+		return credentials.Value{}, time.Time{}, errors.Wrap(sourceErr, "failed to acquire credentials")
+	}
+
+	value, getErr := creds.Get()
+	if getErr != nil {
+		return credentials.Value{}, time.Time{}, errors.Wrap(getErr, "failed to get credentials value")
+	}
+
+	expiration, _ := expiring.ExpiresAt(creds)
+
+	r.value = value
+	r.expiration = expiration
+
+	return r.value, r.expiration, nil
+}
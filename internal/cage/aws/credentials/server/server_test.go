@@ -0,0 +1,100 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package server_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/endpointcreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/server"
+)
+
+// source returns new credentials, which expire after ttl, on every call.
+type source struct {
+	calls int
+	ttl   time.Duration
+}
+
+func (s *source) Get(minRemaining time.Duration) (*credentials.Credentials, error) {
+	s.calls++
+	return expiring.NewCredentials(
+		credentials.Value{AccessKeyID: fmt.Sprintf("id%d", s.calls), SecretAccessKey: "secret", SessionToken: "token"},
+		time.Now().Add(s.ttl),
+	), nil
+}
+
+func TestRefresher(t *testing.T) {
+	s := &source{ttl: time.Hour}
+	r := server.NewRefresher(s.Get, 5*time.Minute)
+
+	v, expiration, err := r.Get()
+	require.NoError(t, err)
+	require.Exactly(t, "id1", v.AccessKeyID)
+	require.WithinDuration(t, time.Now().Add(time.Hour), expiration, 5*time.Second)
+
+	v, _, err = r.Get()
+	require.NoError(t, err)
+	require.Exactly(t, "id1", v.AccessKeyID)
+
+	// renew once the credentials expire within Before
+
+	r.Before = 2 * time.Hour
+
+	v, _, err = r.Get()
+	require.NoError(t, err)
+	require.Exactly(t, "id2", v.AccessKeyID)
+}
+
+func TestContainerHandler(t *testing.T) {
+	token := "some-token"
+	s := &source{ttl: time.Hour}
+	srv := httptest.NewServer(&server.ContainerHandler{
+		Refresher: server.NewRefresher(s.Get, 5*time.Minute),
+		AuthToken: token,
+		Err:       ioutil.Discard,
+	})
+	defer srv.Close()
+
+	t.Run("should serve credentials to the SDK", func(t *testing.T) {
+		cfg := defaults.Config().WithHTTPClient(srv.Client())
+		p := endpointcreds.NewProviderClient(*cfg, defaults.Handlers(), srv.URL, func(p *endpointcreds.Provider) {
+			p.AuthorizationToken = token
+		})
+
+		creds := credentials.NewCredentials(p)
+		v, err := creds.Get()
+		require.NoError(t, err)
+		require.Exactly(t, "id1", v.AccessKeyID)
+		require.Exactly(t, "secret", v.SecretAccessKey)
+		require.Exactly(t, "token", v.SessionToken)
+		expiration, err := creds.ExpiresAt()
+		require.NoError(t, err)
+		require.WithinDuration(t, time.Now().Add(time.Hour), expiration, 5*time.Second)
+	})
+
+	t.Run("should reject an invalid token", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "other")
+
+		res, err := srv.Client().Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Exactly(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+}
@@ -0,0 +1,233 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package shared writes credentials into profiles of the shared credentials file,
+// e.g. ~/.aws/credentials.
+package shared
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FilenameEnv is the environment variable from which SDKs read an alternate file location.
+	FilenameEnv = "AWS_SHARED_CREDENTIALS_FILE"
+
+	AccessKeyIDKey     = "aws_access_key_id"
+	SecretAccessKeyKey = "aws_secret_access_key"
+	SessionTokenKey    = "aws_session_token"
+
+	// ExpirationKey is not read by SDKs but is the de facto convention, used by several
+	// credential helpers, for tools which display or check the expiration.
+	ExpirationKey = "x_security_token_expires"
+
+	// FileMode is the mode of written files.
+	FileMode = 0600
+
+	// DirMode is the mode of the file's directory if it is created.
+	DirMode = 0700
+)
+
+var (
+	sectionRe  = regexp.MustCompile(`^\s*\[\s*([^\]]*?)\s*\]`)
+	propertyRe = regexp.MustCompile(`^\s*([^=:;#\s]+)\s*[=:]`)
+)
+
+// Property is a key/value pair in a profile.
+type Property struct {
+	Key   string
+	Value string
+}
+
+// DefaultFilename returns the file location selected by FilenameEnv or ~/.aws/credentials.
+func DefaultFilename() (string, error) {
+	if name := os.Getenv(FilenameEnv); name != "" {
+		return name, nil
+	}
+
+	homeDir, homeErr := homedir.Dir()
+	if homeErr != nil {
+		return "", errors.Wrap(homeErr, "failed to detect home dir")
+	}
+
+	return filepath.Join(homeDir, ".aws", "credentials"), nil
+}
+
+// CredentialsProperties returns the profile properties which provide the credentials.
+//
+// Properties with empty values, e.g. the session token of long-term credentials or
+// the expiration if zero, are included so that Merge removes stale values.
+func CredentialsProperties(v credentials.Value, expiration time.Time) []Property {
+	var expires string
+	if !expiration.IsZero() {
+		expires = expiration.UTC().Format(time.RFC3339)
+	}
+
+	return []Property{
+		{Key: AccessKeyIDKey, Value: v.AccessKeyID},
+		{Key: SecretAccessKeyKey, Value: v.SecretAccessKey},
+		{Key: SessionTokenKey, Value: v.SessionToken},
+		{Key: ExpirationKey, Value: expires},
+	}
+}
+
+// Merge returns the INI content with the properties set in the named profile.
+//
+// Existing properties with the same keys are replaced in place, and properties with
+// empty values are removed. New properties are added after the profile's last property,
+// and the profile is appended if it does not exist. Comments, other properties,
+// and other profiles are preserved.
+func Merge(content []byte, profile string, props []Property) []byte {
+	text := string(content)
+	newline := "\n"
+	if strings.Contains(text, "\r\n") {
+		newline = "\r\n"
+	}
+
+	var lines []string
+	if text != "" {
+		lines = strings.Split(strings.TrimSuffix(strings.Replace(text, "\r\n", "\n", -1), "\n"), "\n")
+	}
+
+	pending := make(map[string]int) // key to index in props
+	for n, p := range props {
+		pending[strings.ToLower(p.Key)] = n
+	}
+
+	var out []string
+	inProfile := false
+	found := false
+	insertAt := -1 // index in out after the profile's last property
+
+	flush := func() {
+		var add []string
+		for _, p := range props {
+			if _, ok := pending[strings.ToLower(p.Key)]; ok && p.Value != "" {
+				add = append(add, p.Key+" = "+p.Value)
+			}
+		}
+		pending = nil
+		out = append(out[:insertAt], append(add, out[insertAt:]...)...)
+	}
+
+	for _, line := range lines {
+		if m := sectionRe.FindStringSubmatch(line); m != nil {
+			if inProfile {
+				flush()
+			}
+			inProfile = m[1] == profile
+			if inProfile {
+				found = true
+				out = append(out, line)
+				insertAt = len(out)
+				continue
+			}
+		}
+
+		if inProfile {
+			if m := propertyRe.FindStringSubmatch(line); m != nil {
+				key := strings.ToLower(m[1])
+				if n, ok := pending[key]; ok {
+					delete(pending, key)
+					if props[n].Value != "" {
+						out = append(out, props[n].Key+" = "+props[n].Value)
+						insertAt = len(out)
+					}
+					continue
+				}
+				if isManaged(props, key) { // a duplicate of a replaced property
+					continue
+				}
+				out = append(out, line)
+				insertAt = len(out)
+				continue
+			}
+		}
+
+		out = append(out, line)
+	}
+
+	if inProfile {
+		flush()
+	}
+
+	if !found {
+		if len(out) > 0 && strings.TrimSpace(out[len(out)-1]) != "" {
+			out = append(out, "")
+		}
+		out = append(out, "["+profile+"]")
+		insertAt = len(out)
+		flush()
+	}
+
+	return []byte(strings.Join(out, newline) + newline)
+}
+
+// WriteProfile merges the properties into the named profile of the file and replaces
+// the file atomically with mode FileMode.
+//
+// If the file is a symlink, its target is replaced instead.
+func WriteProfile(filename, profile string, props []Property) error {
+	if target, linkErr := filepath.EvalSymlinks(filename); linkErr == nil {
+		filename = target
+	}
+
+	content, readErr := ioutil.ReadFile(filename) // #nosec
+	if readErr != nil && !os.IsNotExist(readErr) {
+		return errors.Wrapf(readErr, "failed to read file [%s]", filename)
+	}
+
+	dir := filepath.Dir(filename)
+	if mkdirErr := os.MkdirAll(dir, DirMode); mkdirErr != nil {
+		return errors.Wrapf(mkdirErr, "failed to create dir [%s]", dir)
+	}
+
+	tmp, tmpErr := ioutil.TempFile(dir, "."+filepath.Base(filename)+".")
+	if tmpErr != nil {
+		return errors.Wrapf(tmpErr, "failed to create temp file in [%s]", dir)
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+
+	if _, writeErr := tmp.Write(Merge(content, profile, props)); writeErr != nil {
+		tmp.Close()
+		return errors.Wrapf(writeErr, "failed to write temp file [%s]", tmp.Name())
+	}
+	if chmodErr := tmp.Chmod(FileMode); chmodErr != nil {
+		tmp.Close()
+		return errors.Wrapf(chmodErr, "failed to set mode of temp file [%s]", tmp.Name())
+	}
+	if syncErr := tmp.Sync(); syncErr != nil {
+		tmp.Close()
+		return errors.Wrapf(syncErr, "failed to sync temp file [%s]", tmp.Name())
+	}
+	if closeErr := tmp.Close(); closeErr != nil {
+		return errors.Wrapf(closeErr, "failed to close temp file [%s]", tmp.Name())
+	}
+
+	if renameErr := os.Rename(tmp.Name(), filename); renameErr != nil {
+		return errors.Wrapf(renameErr, "failed to replace file [%s]", filename)
+	}
+
+	return nil
+}
+
+func isManaged(props []Property, key string) bool {
+	for _, p := range props {
+		if strings.ToLower(p.Key) == key {
+			return true
+		}
+	}
+	return false
+}
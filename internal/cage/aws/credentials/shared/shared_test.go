@@ -0,0 +1,79 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package shared_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/shared"
+)
+
+func TestMerge(t *testing.T) {
+	props := []shared.Property{
+		{Key: shared.AccessKeyIDKey, Value: "id"},
+		{Key: shared.SecretAccessKeyKey, Value: "secret"},
+		{Key: shared.SessionTokenKey, Value: ""},
+	}
+
+	t.Run("should append a profile", func(t *testing.T) {
+		in := "# comment\n[default]\naws_access_key_id = other\n"
+		expected := "# comment\n[default]\naws_access_key_id = other\n\n[dev]\naws_access_key_id = id\naws_secret_access_key = secret\n"
+		require.Exactly(t, expected, string(shared.Merge([]byte(in), "dev", props)))
+	})
+
+	t.Run("should create a file", func(t *testing.T) {
+		expected := "[dev]\naws_access_key_id = id\naws_secret_access_key = secret\n"
+		require.Exactly(t, expected, string(shared.Merge(nil, "dev", props)))
+	})
+
+	t.Run("should replace properties in place", func(t *testing.T) {
+		in := "[dev]\n; keep\nAWS_ACCESS_KEY_ID=old\nregion = us-east-1\naws_session_token = old\naws_access_key_id = dup\n\n[default]\naws_access_key_id = other\n"
+		expected := "[dev]\n; keep\naws_access_key_id = id\nregion = us-east-1\naws_secret_access_key = secret\n\n[default]\naws_access_key_id = other\n"
+		require.Exactly(t, expected, string(shared.Merge([]byte(in), "dev", props)))
+	})
+
+	t.Run("should preserve CRLF line endings", func(t *testing.T) {
+		in := "[default]\r\naws_access_key_id = other\r\n"
+		expected := "[default]\r\naws_access_key_id = other\r\n\r\n[dev]\r\naws_access_key_id = id\r\naws_secret_access_key = secret\r\n"
+		require.Exactly(t, expected, string(shared.Merge([]byte(in), "dev", props)))
+	})
+}
+
+func TestWriteProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shared_test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, ".aws", "credentials")
+	expiration := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	props := shared.CredentialsProperties(credentials.Value{AccessKeyID: "id", SecretAccessKey: "secret", SessionToken: "token"}, expiration)
+
+	require.NoError(t, shared.WriteProfile(filename, "dev", props))
+
+	content, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.Exactly(
+		t,
+		"[dev]\naws_access_key_id = id\naws_secret_access_key = secret\naws_session_token = token\nx_security_token_expires = 2020-01-02T03:04:05Z\n",
+		string(content),
+	)
+
+	fi, err := os.Stat(filename)
+	require.NoError(t, err)
+	require.Exactly(t, os.FileMode(shared.FileMode), fi.Mode().Perm())
+
+	entries, err := ioutil.ReadDir(filepath.Dir(filename))
+	require.NoError(t, err)
+	require.Len(t, entries, 1) // no leftover temp file
+}
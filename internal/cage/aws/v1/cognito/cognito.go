@@ -8,14 +8,30 @@ package cognito
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/service/cognitoidentity"
+	"github.com/aws/aws-sdk-go/service/cognitoidentity/cognitoidentityiface"
 	"github.com/pkg/errors"
 
 	core_cognito "github.com/codeactual/aws-exec-cmd/internal/cage/aws/cognito"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
 )
 
-func SingleIdentityLogin(svc *cognitoidentity.CognitoIdentity, poolId, providerName, providerToken string) (core_cognito.IdentityLoginResult, error) {
+// SingleIdentityLogin returns credentials for the identity, in the pool, which the provider token authenticates.
+func SingleIdentityLogin(svc cognitoidentityiface.CognitoIdentityAPI, poolId, providerName, providerToken string) (core_cognito.IdentityLoginResult, error) {
+	identityId, err := GetId(svc, poolId, providerName, providerToken)
+	if err != nil {
+		return core_cognito.IdentityLoginResult{}, errors.WithStack(err)
+	}
+
+	return GetCredentialsForIdentity(svc, identityId, poolId, providerName, providerToken)
+}
+
+// GetId returns the ID of the identity, in the pool, which the provider token authenticates.
+//
+// The ID does not change for a given pool, provider, and provider user.
+func GetId(svc cognitoidentityiface.CognitoIdentityAPI, poolId, providerName, providerToken string) (string, error) {
 	userId, err := svc.GetId(&cognitoidentity.GetIdInput{
 		IdentityPoolId: aws.String(poolId),
 		Logins: map[string]*string{
@@ -23,11 +39,18 @@ func SingleIdentityLogin(svc *cognitoidentity.CognitoIdentity, poolId, providerN
 		},
 	})
 	if err != nil {
-		return core_cognito.IdentityLoginResult{}, errors.Wrapf(err, "failed to get user identity from pool [%s] using provider [%s] with [%d] length token", poolId, providerName, len(providerToken))
+		return "", errors.Wrapf(err, "failed to get user identity from pool [%s] using provider [%s] with [%d] length token", poolId, providerName, len(providerToken))
 	}
 
+	return *userId.IdentityId, nil
+}
+
+// GetCredentialsForIdentity returns credentials for an identity ID from GetId.
+//
+// The pool ID is only used in error messages.
+func GetCredentialsForIdentity(svc cognitoidentityiface.CognitoIdentityAPI, identityId, poolId, providerName, providerToken string) (core_cognito.IdentityLoginResult, error) {
 	creds, err := svc.GetCredentialsForIdentity(&cognitoidentity.GetCredentialsForIdentityInput{
-		IdentityId: userId.IdentityId,
+		IdentityId: aws.String(identityId),
 		Logins: map[string]*string{
 			providerName: aws.String(providerToken),
 		},
@@ -37,12 +60,21 @@ func SingleIdentityLogin(svc *cognitoidentity.CognitoIdentity, poolId, providerN
 	}
 
 	return core_cognito.IdentityLoginResult{
-		Creds: credentials.NewStaticCredentials(
-			*creds.Credentials.AccessKeyId,
-			*creds.Credentials.SecretKey,
-			*creds.Credentials.SessionToken,
+		Creds: expiring.NewCredentials(
+			credentials.Value{
+				AccessKeyID:     *creds.Credentials.AccessKeyId,
+				SecretAccessKey: *creds.Credentials.SecretKey,
+				SessionToken:    *creds.Credentials.SessionToken,
+			},
+			*creds.Credentials.Expiration,
 		),
 		Expiration: *creds.Credentials.Expiration,
 		IdentityId: *creds.IdentityId,
 	}, nil
 }
+
+// IsNotAuthorized returns true if the error, or its cause, is a NotAuthorizedException.
+func IsNotAuthorized(err error) bool {
+	awsErr, ok := errors.Cause(err).(awserr.Error)
+	return ok && awsErr.Code() == cognitoidentity.ErrCodeNotAuthorizedException
+}
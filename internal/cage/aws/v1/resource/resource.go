@@ -6,9 +6,58 @@
 
 package resource
 
-import "regexp"
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
 
 // IsARN returns true if the string begins with ARN format.
 func IsARN(str string) bool {
 	return regexp.MustCompile("^arn:aws:").MatchString(str)
 }
+
+// AssumedRole holds the parts of an assumed-role session ARN, e.g.
+// "arn:aws:sts::123456789012:assumed-role/name/session".
+type AssumedRole struct {
+	Partition   string
+	AccountID   string
+	RoleName    string
+	SessionName string
+}
+
+// RoleARN returns the ARN of the role, without its path which the session ARN omits.
+func (r AssumedRole) RoleARN() string {
+	return "arn:" + r.Partition + ":iam::" + r.AccountID + ":role/" + r.RoleName
+}
+
+// ParseAssumedRoleARN returns the parts of an assumed-role session ARN.
+func ParseAssumedRoleARN(arn string) (r AssumedRole, err error) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sts" {
+		return AssumedRole{}, errors.Errorf("ARN [%s] is not an STS ARN", arn)
+	}
+
+	resource := strings.Split(parts[5], "/")
+	if len(resource) != 3 || resource[0] != "assumed-role" {
+		return AssumedRole{}, errors.Errorf("ARN [%s] is not an assumed-role session ARN", arn)
+	}
+
+	return AssumedRole{
+		Partition:   parts[1],
+		AccountID:   parts[4],
+		RoleName:    resource[1],
+		SessionName: resource[2],
+	}, nil
+}
+
+// AccountID returns the account ID of an ARN, e.g. "123456789012" from
+// "arn:aws:iam::123456789012:role/name", or an empty string if it has none.
+func AccountID(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[4]
+}
@@ -0,0 +1,32 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package resource_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/v1/resource"
+)
+
+func TestParseAssumedRoleARN(t *testing.T) {
+	r, err := resource.ParseAssumedRoleARN("arn:aws:sts::123456789012:assumed-role/backup/session.1")
+	require.NoError(t, err)
+	require.Exactly(t, resource.AssumedRole{Partition: "aws", AccountID: "123456789012", RoleName: "backup", SessionName: "session.1"}, r)
+	require.Exactly(t, "arn:aws:iam::123456789012:role/backup", r.RoleARN())
+
+	_, err = resource.ParseAssumedRoleARN("arn:aws:iam::123456789012:role/backup")
+	require.Error(t, err)
+	_, err = resource.ParseAssumedRoleARN("arn:aws:sts::123456789012:federated-user/name")
+	require.Error(t, err)
+}
+
+func TestAccountID(t *testing.T) {
+	require.Exactly(t, "123456789012", resource.AccountID("arn:aws:iam::123456789012:role/path/backup"))
+	require.Exactly(t, "", resource.AccountID("instance"))
+}
@@ -7,8 +7,11 @@
 package sts
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -19,6 +22,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/cache"
 	cage_resource "github.com/codeactual/aws-exec-cmd/internal/cage/aws/v1/resource"
 	cage_crypto "github.com/codeactual/aws-exec-cmd/internal/cage/crypto"
 )
@@ -37,6 +41,10 @@ const (
 	// AWS_SECRET_ACCESS_KEY
 	// AWS_SESSION_TOKEN
 	EnvTempRoleChainAlias = "env-triple"
+
+	// linkCacheEarlyTtl reduces the opportunity for a cached chain link to expire before
+	// it is used to assume the next link.
+	linkCacheEarlyTtl = 10 * time.Second
 )
 
 // ResolveRoleChainInput describes the chain to traverse and initial credentials, if any.
@@ -59,8 +67,51 @@ type ResolveRoleChainInput struct {
 	SerialNumber string
 	// TokenCode is a code from an MFA device.
 	TokenCode string
+	// TokenProvider returns a code from an MFA device if TokenCode is empty.
+	//
+	// It is only called if the first role assumption in the chain is performed,
+	// e.g. not if its credentials were read from Cache.
+	TokenProvider func() (string, error)
 	// DurationSeconds is the session lifetime (min 900).
 	DurationSeconds int64
+	// MfaSession, if SerialNumber is set and an alias seeds the chain, uses the MFA code once
+	// with GetSessionToken and assumes the first role with the resulting session instead of
+	// passing the code to AssumeRole.
+	//
+	// The session is stored in Cache under the alias, so chains which share the seed
+	// but not their first role can reuse one MFA code. It is ignored unless the seed credentials
+	// are long-term, e.g. an IAM user's keys provided via EnvTempRoleChainAlias without a session token.
+	MfaSession bool
+	// Cache, if non-nil, stores the credentials of each chain prefix which ends with an ARN.
+	// The traversal resumes from the longest prefix whose credentials have not expired.
+	//
+	// It is not used if AccessKey and SecretAccessKey seed the traversal.
+	Cache cache.Backend
+	// CacheSkip disables Cache reads but not writes.
+	CacheSkip bool
+	// CacheMinRemaining is the shortest lifetime of cached credentials for the full chain
+	// which may be returned. Intermediate prefixes only need to remain valid long enough
+	// to assume the next link.
+	CacheMinRemaining time.Duration
+}
+
+// ResolveRoleChainOutput describes the final credentials of a chain traversal.
+type ResolveRoleChainOutput struct {
+	Creds credentials.Value
+
+	// Expiration is the zero value if no role was assumed or read from Cache,
+	// e.g. if the chain only contained an alias.
+	Expiration time.Time
+
+	// Resumed is the number of chain links whose credentials were read from Cache.
+	Resumed int
+
+	// Arn is the assumed-role session ARN of the final link, e.g.
+	// "arn:aws:sts::123456789012:assumed-role/name/session".
+	//
+	// It is empty if no role was assumed or if the link was read from a Cache entry
+	// written before the ARN was recorded.
+	Arn string
 }
 
 func (i ResolveRoleChainInput) String() string {
@@ -105,9 +156,25 @@ func GetEC2RoleCreds() (credentials.Value, error) {
 
 // GetAssumeRoleCreds returns credentials using the given role.
 func GetAssumeRoleCreds(arn string, input *ResolveRoleChainInput, config *aws.Config) (creds credentials.Value, err error) {
+	creds, _, err = GetAssumeRoleCredsWithExpiration(arn, input, config)
+	return creds, err
+}
+
+// GetAssumeRoleCredsWithExpiration returns credentials using the given role and the time they expire.
+func GetAssumeRoleCredsWithExpiration(arn string, input *ResolveRoleChainInput, config *aws.Config) (creds credentials.Value, expiration time.Time, err error) {
+	resp, err := GetAssumeRoleOutput(arn, input, config)
+	if err != nil {
+		return credentials.Value{}, time.Time{}, errors.WithStack(err)
+	}
+	return SvcToBasicCreds(resp.Credentials), aws.TimeValue(resp.Credentials.Expiration), nil
+}
+
+// GetAssumeRoleOutput returns the AssumeRole response for the given role, e.g. for callers
+// which also need the assumed-role user.
+func GetAssumeRoleOutput(arn string, input *ResolveRoleChainInput, config *aws.Config) (*sts.AssumeRoleOutput, error) {
 	sess, err := session.NewSession(config)
 	if err != nil {
-		return credentials.Value{}, errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 	svc := sts.New(sess)
 
@@ -123,7 +190,7 @@ func GetAssumeRoleCreds(arn string, input *ResolveRoleChainInput, config *aws.Co
 	if input.SessionName == "" {
 		randStr, randStrErr := cage_crypto.RandHexString(2)
 		if randStrErr != nil {
-			return credentials.Value{}, errors.Wrap(err, "failed to generate random AssumeRole session name")
+			return nil, errors.Wrap(randStrErr, "failed to generate random AssumeRole session name")
 		}
 		params.RoleSessionName = aws.String("cage.aws.v1.sts.GetAssumeRoleCreds." + randStr)
 	} else {
@@ -142,10 +209,32 @@ func GetAssumeRoleCreds(arn string, input *ResolveRoleChainInput, config *aws.Co
 
 	resp, err := svc.AssumeRole(&params)
 	if err != nil {
-		return credentials.Value{}, errors.WithStack(err)
+		return nil, errors.WithStack(err)
+	}
+
+	return resp, nil
+}
+
+// GetCallerIdentity returns the principal identified by the credentials.
+//
+// The region is optional.
+func GetCallerIdentity(creds *credentials.Credentials, region string) (*sts.GetCallerIdentityOutput, error) {
+	config := &aws.Config{Credentials: creds}
+	if region != "" {
+		config.Region = aws.String(region)
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	resp, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	return SvcToBasicCreds(resp.Credentials), nil
+	return resp, nil
 }
 
 // ResolveRoleChain returns the final credentials triple after walking a list of roles.
@@ -153,6 +242,16 @@ func GetAssumeRoleCreds(arn string, input *ResolveRoleChainInput, config *aws.Co
 // initialCreds can be nil, ex. when the first element of the chain is an instance
 // profile that will seed the traversal.
 func ResolveRoleChain(input *ResolveRoleChainInput) (accessKey string, secretAccessKey string, sessionToken string, err error) {
+	out, err := ResolveRoleChainDetail(input)
+	if err != nil {
+		return "", "", "", errors.WithStack(err)
+	}
+	return out.Creds.AccessKeyID, out.Creds.SecretAccessKey, out.Creds.SessionToken, nil
+}
+
+// ResolveRoleChainDetail behaves the same as ResolveRoleChain but also returns the expiration
+// of the final credentials and whether the traversal resumed from a cached chain prefix.
+func ResolveRoleChainDetail(input *ResolveRoleChainInput) (out ResolveRoleChainOutput, err error) {
 	log := ResolveRoleChainLog{}
 	resolveErr := func(err error) error {
 		return errors.Wrapf(err, "failed to resolve role chain (%s) log [%s]", input, log)
@@ -162,54 +261,174 @@ func ResolveRoleChain(input *ResolveRoleChainInput) (accessKey string, secretAcc
 	prior := credentials.Value{}
 
 	if len(chain) == 0 {
-		return "", "", "", errors.New("no links in role chain")
+		return ResolveRoleChainOutput{}, errors.New("no links in role chain")
 	}
 
+	// Capture the serial before the loop clears it after the first role assumption.
+	// All prefix cache keys include it because every later link derives from that session.
+	mfaSerial := input.SerialNumber
+
+	linkCache := input.Cache
 	if input.AccessKey != "" && input.SecretAccessKey != "" {
-		// E.g. to support use of a keypair on a laptop.
-		awsConfig := &aws.Config{
-			Credentials: credentials.NewStaticCredentials(input.AccessKey, input.SecretAccessKey, input.SessionToken),
-		}
-		prior, err = GetAssumeRoleCreds(chain[0], input, awsConfig.WithRegion(input.Region))
+		linkCache = nil // keys would not reflect the seed credentials
+	}
+
+	// Support aliases that select a credentials source that seeds the role chain,
+	// e.g. EC2 instance role credentials that have permission to assume the next link
+	// that is identified by ARN.
+	//
+	// The seed is read before the cache because its identity is part of the cache keys.
+	seeded := false
+	if !(input.AccessKey != "" && input.SecretAccessKey != "") && !cage_resource.IsARN(chain[0]) {
+		prior, err = SeedCredentials(chain[0])
 		if err != nil {
-			return "", "", "", errors.Wrapf(err, "failed to assume role [%s] using intiial static creds", chain[0])
+			return ResolveRoleChainOutput{}, resolveErr(err)
 		}
-	} else {
-		// Support aliases that select a credentials source that seeds the role chain,
-		// e.g. EC2 instance role credentials that have permission to assume the next link
-		// that is identified by ARN.
-		if !cage_resource.IsARN(chain[0]) {
-			switch chain[0] {
-			case EnvTempRoleChainAlias:
-				prior, err = credentials.NewEnvCredentials().Get()
-				if err != nil {
-					return "", "", "", resolveErr(err)
-				}
+		seeded = true
+
+		log = append(log, fmt.Sprintf("seeded chain with %s creds", chain[0]))
+	}
+
+	// keyChain holds the chain elements used in cache keys.
+	keyChain := chain
+	if seeded {
+		keyChain = append([]string{SeedCacheID(chain[0], prior.AccessKeyID)}, chain[1:]...)
+	}
+
+	// GetSessionToken rejects temporary credentials, e.g. those of an instance role, so their
+	// chains only reuse MFA-authenticated links from the per-prefix cache.
+	mfaSession := input.MfaSession && seeded && prior.SessionToken == ""
+
+	// next is the index, in input.Chain, of the next link to resolve.
+	next := 0
+	if seeded {
+		next = 1
+	}
+
+	assume := func(arn string, config *aws.Config) error {
+		resp, assumeErr := GetAssumeRoleOutput(arn, input, config)
+		if assumeErr != nil {
+			return errors.WithStack(assumeErr)
+		}
+		prior = SvcToBasicCreds(resp.Credentials)
+		out.Expiration = aws.TimeValue(resp.Credentials.Expiration)
+		out.Arn = ""
+		if resp.AssumedRoleUser != nil {
+			out.Arn = aws.StringValue(resp.AssumedRoleUser.Arn)
+		}
+		return nil
+	}
+
+	resumed := false
+
+	if linkCache != nil && !input.CacheSkip {
+		for n := len(chain); n > 0; n-- {
+			if !cage_resource.IsARN(chain[n-1]) {
+				continue
+			}
+
+			cached, readErr := linkCache.Read(linkCacheKey(mfaSerial, keyChain[:n]))
+			if readErr != nil {
+				return ResolveRoleChainOutput{}, resolveErr(readErr)
+			}
+
+			minRemaining := linkCacheEarlyTtl
+			if n == len(chain) && input.CacheMinRemaining > minRemaining {
+				minRemaining = input.CacheMinRemaining
+			}
+
+			if cached.AccessKeyID == "" || time.Until(time.Unix(cached.Expires, 0)) < minRemaining {
+				continue
+			}
+
+			prior = credentials.Value{
+				AccessKeyID:     cached.AccessKeyID,
+				SecretAccessKey: cached.SecretAccessKey,
+				SessionToken:    cached.SessionToken,
+			}
+			out.Expiration = time.Unix(cached.Expires, 0)
+			out.Arn = cached.Arn
+			out.Resumed = n
+			next = n
+			resumed = true
 
-				log = append(log, "seeded chain with env-triple creds")
-			case InstanceRoleChainAlias:
-				prior, err = GetEC2RoleCreds()
-				if err != nil {
-					return "", "", "", resolveErr(err)
+			// Only support MFA for the first role assumption in the chain, which the prefix included.
+			input.SerialNumber = ""
+			input.TokenCode = ""
+
+			log = append(log, fmt.Sprintf("resumed chain from cached link [%s]", chain[n-1]))
+
+			break
+		}
+
+		if !resumed && mfaSession && mfaSerial != "" {
+			cached, readErr := linkCache.Read(linkCacheKey(mfaSerial, keyChain[:1]))
+			if readErr != nil {
+				return ResolveRoleChainOutput{}, resolveErr(readErr)
+			}
+
+			if cached.AccessKeyID != "" && time.Until(time.Unix(cached.Expires, 0)) >= linkCacheEarlyTtl {
+				prior = credentials.Value{
+					AccessKeyID:     cached.AccessKeyID,
+					SecretAccessKey: cached.SecretAccessKey,
+					SessionToken:    cached.SessionToken,
 				}
+				out.Expiration = time.Unix(cached.Expires, 0)
+				out.Resumed = 1
+				resumed = true
+
+				input.SerialNumber = ""
+				input.TokenCode = ""
 
-				log = append(log, "seeded chain with instance role creds")
-			default:
-				return "", "", "", errors.Errorf("role chain first-link alias [%s] is not recognized", chain[0])
+				log = append(log, fmt.Sprintf("resumed chain from cached MFA session of seed [%s]", chain[0]))
 			}
+		}
+	}
 
-			chain = chain[1:] // Simplify the final for-loop.
+	if !resumed {
+		if input.AccessKey != "" && input.SecretAccessKey != "" {
+			// E.g. to support use of a keypair on a laptop.
+			awsConfig := &aws.Config{
+				Credentials: credentials.NewStaticCredentials(input.AccessKey, input.SecretAccessKey, input.SessionToken),
+			}
+			if tokenErr := resolveTokenCode(input); tokenErr != nil {
+				return ResolveRoleChainOutput{}, resolveErr(tokenErr)
+			}
+			if err = assume(chain[0], awsConfig.WithRegion(input.Region)); err != nil {
+				return ResolveRoleChainOutput{}, errors.Wrapf(err, "failed to assume role [%s] using intiial static creds", chain[0])
+			}
+		} else if mfaSession && input.SerialNumber != "" {
+			if err = getMfaSession(input, &prior, &out); err != nil {
+				return ResolveRoleChainOutput{}, resolveErr(err)
+			}
+
+			log = append(log, "acquired MFA session from seed creds")
+
+			if linkCache != nil {
+				k := linkCacheKey(mfaSerial, keyChain[:1])
+				writeErr := linkCache.Write(k, cache.Value{
+					AccessKeyID:     prior.AccessKeyID,
+					SecretAccessKey: prior.SecretAccessKey,
+					SessionToken:    prior.SessionToken,
+					Expires:         out.Expiration.Unix(),
+				})
+				if writeErr != nil {
+					return ResolveRoleChainOutput{}, resolveErr(errors.Wrapf(writeErr, "failed to write cache key [%s]", k))
+				}
+			}
 		}
 	}
 
-	for _, link := range chain {
+	for ; next < len(chain); next++ {
+		link := chain[next]
+
 		if link == "" { // Ex. chain was Split(..., ",") and there's a trailing ","
 			continue
 		}
 
 		if !cage_resource.IsARN(link) {
 			err = errors.Errorf("non-ARN role is only allowed in first chain role, chain [%s]", strings.Join(input.Chain, ","))
-			return "", "", "", resolveErr(err)
+			return ResolveRoleChainOutput{}, resolveErr(err)
 		}
 
 		priorCredsExist := prior.AccessKeyID != ""
@@ -230,9 +449,12 @@ func ResolveRoleChain(input *ResolveRoleChainInput) (accessKey string, secretAcc
 			assumeConfig.Credentials = credentials.AnonymousCredentials
 		}
 
-		prior, err = GetAssumeRoleCreds(link, input, assumeConfig)
-		if err != nil {
-			return "", "", "", resolveErr(err)
+		if tokenErr := resolveTokenCode(input); tokenErr != nil {
+			return ResolveRoleChainOutput{}, resolveErr(tokenErr)
+		}
+
+		if err = assume(link, assumeConfig); err != nil {
+			return ResolveRoleChainOutput{}, resolveErr(err)
 		}
 
 		// Only support MFA for the first role assumption in the chain.
@@ -240,13 +462,126 @@ func ResolveRoleChain(input *ResolveRoleChainInput) (accessKey string, secretAcc
 		input.TokenCode = ""
 
 		log = append(log, "assumed role from link: "+link)
+
+		if linkCache != nil {
+			k := linkCacheKey(mfaSerial, keyChain[:next+1])
+			writeErr := linkCache.Write(k, cache.Value{
+				AccessKeyID:     prior.AccessKeyID,
+				SecretAccessKey: prior.SecretAccessKey,
+				SessionToken:    prior.SessionToken,
+				Expires:         out.Expiration.Unix(),
+				Arn:             out.Arn,
+			})
+			if writeErr != nil {
+				return ResolveRoleChainOutput{}, resolveErr(errors.Wrapf(writeErr, "failed to write cache key [%s]", k))
+			}
+		}
+	}
+
+	out.Creds = prior
+
+	return out, nil
+}
+
+// getMfaSession replaces the prior (seed) credentials with an MFA-authenticated session
+// from GetSessionToken and clears the MFA fields so later links do not reuse the code.
+func getMfaSession(input *ResolveRoleChainInput, prior *credentials.Value, out *ResolveRoleChainOutput) error {
+	if tokenErr := resolveTokenCode(input); tokenErr != nil {
+		return errors.WithStack(tokenErr)
+	}
+
+	config := &aws.Config{
+		Region:      aws.String(input.Region),
+		Credentials: credentials.NewStaticCredentials(prior.AccessKeyID, prior.SecretAccessKey, prior.SessionToken),
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return errors.WithStack(err)
 	}
 
-	accessKey = prior.AccessKeyID
-	secretAccessKey = prior.SecretAccessKey
-	sessionToken = prior.SessionToken
+	params := sts.GetSessionTokenInput{
+		SerialNumber: aws.String(input.SerialNumber),
+		TokenCode:    aws.String(input.TokenCode),
+	}
+	if input.DurationSeconds > 0 {
+		params.DurationSeconds = aws.Int64(input.DurationSeconds)
+	}
+
+	resp, err := sts.New(sess).GetSessionToken(&params)
+	if err != nil {
+		return errors.Wrap(err, "failed to get MFA session token")
+	}
+
+	*prior = SvcToBasicCreds(resp.Credentials)
+	out.Expiration = aws.TimeValue(resp.Credentials.Expiration)
+
+	input.SerialNumber = ""
+	input.TokenCode = ""
+
+	return nil
+}
+
+// SeedCredentials returns the credentials selected by a seed alias, e.g. EnvTempRoleChainAlias.
+func SeedCredentials(alias string) (credentials.Value, error) {
+	switch alias {
+	case EnvTempRoleChainAlias:
+		creds, err := credentials.NewEnvCredentials().Get()
+		return creds, errors.WithStack(err)
+	case InstanceRoleChainAlias:
+		creds, err := GetEC2RoleCreds()
+		return creds, errors.WithStack(err)
+	default:
+		return credentials.Value{}, errors.Errorf("role chain first-link alias [%s] is not recognized", alias)
+	}
+}
+
+// SeedCacheID returns the seed alias as it appears in cache keys, e.g. "env-triple@0123456789abcdef",
+// which identifies the seed credentials by a hash of their access key ID.
+//
+// It prevents chains seeded by other credentials, e.g. another IAM user's keys in the environment,
+// from reusing each other's cached links.
+func SeedCacheID(alias, accessKeyID string) string {
+	hash := sha256.Sum256([]byte(accessKeyID))
+	return alias + "@" + hex.EncodeToString(hash[:8])
+}
+
+// CacheChain returns the chain as it appears in cache keys, with the seed alias, if any,
+// replaced by its SeedCacheID.
+//
+// It reads the seed credentials, e.g. from the metadata service, to identify them.
+func CacheChain(chain []string) ([]string, error) {
+	if len(chain) == 0 || cage_resource.IsARN(chain[0]) {
+		return chain, nil
+	}
+
+	seed, err := SeedCredentials(chain[0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the credentials of seed alias [%s]", chain[0])
+	}
+
+	return append([]string{SeedCacheID(chain[0], seed.AccessKeyID)}, chain[1:]...), nil
+}
+
+// linkCacheKey returns the key of a chain prefix's credentials.
+//
+// The prefix elements are those of CacheChain.
+func linkCacheKey(mfaSerial string, prefix []string) cache.Key {
+	return cache.Key{MfaSerial: mfaSerial, Role: strings.Join(prefix, ",")}
+}
+
+// resolveTokenCode assigns TokenCode from TokenProvider if an MFA code is required but missing.
+func resolveTokenCode(input *ResolveRoleChainInput) error {
+	if input.SerialNumber == "" || input.TokenCode != "" || input.TokenProvider == nil {
+		return nil
+	}
+
+	code, err := input.TokenProvider()
+	if err != nil {
+		return errors.Wrap(err, "failed to get MFA code")
+	}
+	input.TokenCode = code
 
-	return accessKey, secretAccessKey, sessionToken, nil
+	return nil
 }
 
 // SvcToBasicCreds returns a basic credentials triple from the STS version.
@@ -0,0 +1,231 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package sts_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/cache"
+	cage_sts "github.com/codeactual/aws-exec-cmd/internal/cage/aws/v1/sts"
+)
+
+const (
+	roleA = "arn:aws:iam::123456789012:role/a"
+	roleB = "arn:aws:iam::123456789012:role/b"
+)
+
+// setEnvCreds selects the env-triple seed credentials.
+func setEnvCreds(t *testing.T, accessKeyID, sessionToken string) {
+	require.NoError(t, os.Setenv("AWS_ACCESS_KEY_ID", accessKeyID))
+	require.NoError(t, os.Setenv("AWS_SECRET_ACCESS_KEY", "secret"))
+	require.NoError(t, os.Setenv("AWS_SESSION_TOKEN", sessionToken))
+}
+
+func TestResolveRoleChainDetailResume(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Unix()
+
+	t.Run("should resume from the longest cached prefix without an MFA code", func(t *testing.T) {
+		c := cache.NewMemory()
+		require.NoError(t, c.Write(cache.Key{MfaSerial: "serial", Role: roleA}, cache.Value{AccessKeyID: "a", Expires: expires}))
+		require.NoError(t, c.Write(cache.Key{MfaSerial: "serial", Role: roleA + "," + roleB}, cache.Value{AccessKeyID: "b", Expires: expires, Arn: "arn:aws:sts::123456789012:assumed-role/b/s"}))
+
+		out, err := cage_sts.ResolveRoleChainDetail(&cage_sts.ResolveRoleChainInput{
+			Chain:        []string{roleA, roleB},
+			SerialNumber: "serial",
+			TokenProvider: func() (string, error) {
+				return "", errors.New("should not prompt")
+			},
+			Cache: c,
+		})
+		require.NoError(t, err)
+		require.Exactly(t, "b", out.Creds.AccessKeyID)
+		require.Exactly(t, 2, out.Resumed)
+		require.Exactly(t, expires, out.Expiration.Unix())
+		require.Exactly(t, "arn:aws:sts::123456789012:assumed-role/b/s", out.Arn)
+	})
+
+	t.Run("should key prefixes by the seed credentials", func(t *testing.T) {
+		setEnvCreds(t, "seed", "")
+
+		c := cache.NewMemory()
+		seedID := cage_sts.SeedCacheID(cage_sts.EnvTempRoleChainAlias, "seed")
+		require.NoError(t, c.Write(cache.Key{Role: seedID + "," + roleA}, cache.Value{AccessKeyID: "a", Expires: expires}))
+
+		out, err := cage_sts.ResolveRoleChainDetail(&cage_sts.ResolveRoleChainInput{
+			Chain: []string{cage_sts.EnvTempRoleChainAlias, roleA},
+			Cache: c,
+		})
+		require.NoError(t, err)
+		require.Exactly(t, "a", out.Creds.AccessKeyID)
+		require.Exactly(t, 2, out.Resumed)
+	})
+
+	t.Run("should ignore prefixes seeded by other credentials", func(t *testing.T) {
+		setEnvCreds(t, "seed", "")
+
+		c := cache.NewMemory()
+		for _, role := range []string{
+			cage_sts.EnvTempRoleChainAlias + "," + roleA,
+			cage_sts.SeedCacheID(cage_sts.EnvTempRoleChainAlias, "other") + "," + roleA,
+		} {
+			require.NoError(t, c.Write(cache.Key{MfaSerial: "serial", Role: role}, cache.Value{AccessKeyID: "a", Expires: expires}))
+		}
+
+		_, err := cage_sts.ResolveRoleChainDetail(&cage_sts.ResolveRoleChainInput{
+			Chain:        []string{cage_sts.EnvTempRoleChainAlias, roleA},
+			SerialNumber: "serial",
+			TokenProvider: func() (string, error) {
+				return "", errors.New("prompt attempted")
+			},
+			Cache: c,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "prompt attempted")
+	})
+
+	t.Run("should ignore prefixes keyed by another MFA serial", func(t *testing.T) {
+		c := cache.NewMemory()
+		require.NoError(t, c.Write(cache.Key{MfaSerial: "other", Role: roleA}, cache.Value{AccessKeyID: "a", Expires: expires}))
+
+		_, err := cage_sts.ResolveRoleChainDetail(&cage_sts.ResolveRoleChainInput{
+			Chain:        []string{roleA},
+			SerialNumber: "serial",
+			TokenProvider: func() (string, error) {
+				return "", errors.New("prompt attempted")
+			},
+			Cache: c,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "prompt attempted")
+	})
+
+	t.Run("should not read the cache if skipped", func(t *testing.T) {
+		c := cache.NewMemory()
+		require.NoError(t, c.Write(cache.Key{MfaSerial: "serial", Role: roleA}, cache.Value{AccessKeyID: "a", Expires: expires}))
+
+		_, err := cage_sts.ResolveRoleChainDetail(&cage_sts.ResolveRoleChainInput{
+			Chain:        []string{roleA},
+			SerialNumber: "serial",
+			TokenProvider: func() (string, error) {
+				return "", errors.New("prompt attempted")
+			},
+			Cache:     c,
+			CacheSkip: true,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "prompt attempted")
+	})
+
+	t.Run("should not resume the full chain if it expires before CacheMinRemaining", func(t *testing.T) {
+		c := cache.NewMemory()
+		require.NoError(t, c.Write(cache.Key{Role: cage_sts.EnvTempRoleChainAlias + "," + roleA}, cache.Value{AccessKeyID: "a", Expires: time.Now().Add(5 * time.Minute).Unix()}))
+
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_ACCESS_KEY")
+
+		_, err := cage_sts.ResolveRoleChainDetail(&cage_sts.ResolveRoleChainInput{
+			Chain:             []string{cage_sts.EnvTempRoleChainAlias, roleA},
+			Cache:             c,
+			CacheMinRemaining: 10 * time.Minute,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "EnvAccessKeyNotFound")
+	})
+
+	t.Run("should resume from the cached MFA session of the seed alias", func(t *testing.T) {
+		setEnvCreds(t, "seed", "")
+
+		c := cache.NewMemory()
+		seedID := cage_sts.SeedCacheID(cage_sts.EnvTempRoleChainAlias, "seed")
+		require.NoError(t, c.Write(cache.Key{MfaSerial: "serial", Role: seedID}, cache.Value{AccessKeyID: "session", Expires: expires}))
+
+		out, err := cage_sts.ResolveRoleChainDetail(&cage_sts.ResolveRoleChainInput{
+			Chain:        []string{cage_sts.EnvTempRoleChainAlias},
+			SerialNumber: "serial",
+			TokenProvider: func() (string, error) {
+				return "", errors.New("should not prompt")
+			},
+			Cache:      c,
+			MfaSession: true,
+		})
+		require.NoError(t, err)
+		require.Exactly(t, "session", out.Creds.AccessKeyID)
+		require.Exactly(t, 1, out.Resumed)
+		require.Exactly(t, expires, out.Expiration.Unix())
+	})
+
+	t.Run("should not use an MFA session for temporary seed credentials", func(t *testing.T) {
+		setEnvCreds(t, "temp", "token")
+
+		c := cache.NewMemory()
+		seedID := cage_sts.SeedCacheID(cage_sts.EnvTempRoleChainAlias, "temp")
+		require.NoError(t, c.Write(cache.Key{MfaSerial: "serial", Role: seedID}, cache.Value{AccessKeyID: "session", Expires: expires}))
+
+		out, err := cage_sts.ResolveRoleChainDetail(&cage_sts.ResolveRoleChainInput{
+			Chain:        []string{cage_sts.EnvTempRoleChainAlias},
+			SerialNumber: "serial",
+			TokenProvider: func() (string, error) {
+				return "", errors.New("should not prompt")
+			},
+			Cache:      c,
+			MfaSession: true,
+		})
+		require.NoError(t, err)
+		require.Exactly(t, "temp", out.Creds.AccessKeyID)
+		require.Exactly(t, "token", out.Creds.SessionToken)
+		require.Exactly(t, 0, out.Resumed)
+	})
+
+	t.Run("should resume a chain with temporary seed credentials from its cached prefix", func(t *testing.T) {
+		setEnvCreds(t, "temp", "token")
+
+		c := cache.NewMemory()
+		seedID := cage_sts.SeedCacheID(cage_sts.EnvTempRoleChainAlias, "temp")
+		require.NoError(t, c.Write(cache.Key{MfaSerial: "serial", Role: seedID}, cache.Value{AccessKeyID: "session", Expires: expires}))
+		require.NoError(t, c.Write(cache.Key{MfaSerial: "serial", Role: seedID + "," + roleA}, cache.Value{AccessKeyID: "a", Expires: expires}))
+
+		out, err := cage_sts.ResolveRoleChainDetail(&cage_sts.ResolveRoleChainInput{
+			Chain:        []string{cage_sts.EnvTempRoleChainAlias, roleA},
+			SerialNumber: "serial",
+			TokenProvider: func() (string, error) {
+				return "", errors.New("should not prompt")
+			},
+			Cache:      c,
+			MfaSession: true,
+		})
+		require.NoError(t, err)
+		require.Exactly(t, "a", out.Creds.AccessKeyID)
+		require.Exactly(t, 2, out.Resumed)
+	})
+}
+
+func TestCacheChain(t *testing.T) {
+	t.Run("should identify the seed credentials", func(t *testing.T) {
+		setEnvCreds(t, "seed", "")
+
+		chain, err := cage_sts.CacheChain([]string{cage_sts.EnvTempRoleChainAlias, roleA})
+		require.NoError(t, err)
+		require.Exactly(t, []string{cage_sts.SeedCacheID(cage_sts.EnvTempRoleChainAlias, "seed"), roleA}, chain)
+
+		setEnvCreds(t, "other", "")
+
+		other, err := cage_sts.CacheChain([]string{cage_sts.EnvTempRoleChainAlias, roleA})
+		require.NoError(t, err)
+		require.NotEqual(t, chain[0], other[0])
+	})
+
+	t.Run("should not change chains without an alias", func(t *testing.T) {
+		chain, err := cage_sts.CacheChain([]string{roleA, roleB})
+		require.NoError(t, err)
+		require.Exactly(t, []string{roleA, roleB}, chain)
+	})
+}
@@ -10,6 +10,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/pkg/errors"
 	std_cobra "github.com/spf13/cobra"
@@ -171,6 +172,10 @@ func NewCommand(h Handler, init Init) *std_cobra.Command {
 			}
 		}
 	}
+	// The rendered usage becomes a template, so keep literal delimiters, e.g. in a flag's
+	// usage example, from being evaluated.
+	usageTmpl = strings.Replace(usageTmpl, "{{", `{{"{{"}}`, -1)
+
 	init.Cmd.SetUsageTemplate(usageTmpl + "\n")
 
 	// Don't always display the error returned by handler.Run and the usage info.
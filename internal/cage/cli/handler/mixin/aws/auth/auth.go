@@ -7,10 +7,15 @@
 package auth
 
 import (
+	"bytes"
 	"context"
-	"log"
+	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -19,6 +24,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/cache"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
 	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler"
 	"github.com/codeactual/aws-exec-cmd/internal/cage/os/terminal"
 	cage_reflect "github.com/codeactual/aws-exec-cmd/internal/cage/reflect"
@@ -30,30 +36,93 @@ const (
 
 	defaultHomeCacheDir = ".cage-aws-cache"
 
-	// cacheEarlyTtlSec reduces the opportunity for a command to receive a cached session
+	// DefaultMinRemainingSec reduces the opportunity for a command to receive a cached session
 	// that expires before use.
-	cacheEarlyTtlSec = 10
+	DefaultMinRemainingSec = 10
 )
 
 type ProviderInput struct {
-	Ctx           context.Context
-	MfaSerial     string
-	MfaCode       string
+	Ctx context.Context
+
+	// Cache may be used by providers which can reuse intermediate results, e.g. role chain links.
+	Cache cache.Backend
+
+	// CacheSkip indicates that Cache should not be read (but still written).
+	CacheSkip bool
+
+	// MinRemaining is the shortest lifetime of credentials a provider may return from Cache.
+	MinRemaining time.Duration
+
+	MfaSerial string
+
+	// MfaCode returns a code from the MFA source. It is only called by providers which
+	// need one, so a cache hit does not require user interaction.
+	MfaCode func() (string, error)
+
+	// MfaSession requests that providers which support it use the MFA code once to create a session
+	// which is shared by credentials with the same source, e.g. role chains with the same seed.
+	MfaSession bool
+
+	// Region selects the regional endpoints of the provider's AWS services, if non-empty.
+	Region string
+
 	RoleChain     string
 	SessionTtlSec int
 }
 
+// Result holds credentials and details about them.
+type Result struct {
+	Creds *credentials.Credentials
+
+	// Arn identifies the principal of the credentials, if known, e.g. the assumed-role
+	// session ARN "arn:aws:sts::123456789012:assumed-role/name/session".
+	Arn string
+
+	// Cached is true if the credentials were read from the cache instead of the provider.
+	Cached bool
+}
+
 type Provider interface {
-	Get(ProviderInput) (*credentials.Credentials, error)
+	Get(ProviderInput) (Result, error)
+}
+
+// CacheKeyer is optionally implemented by a Provider whose credentials are not identified
+// by the role chain alone, e.g. an identity pool.
+type CacheKeyer interface {
+	// CacheRole returns the value of cache.Key.Role used to cache the provider's credentials.
+	CacheRole() string
+}
+
+// ChainCacheKeyer is optionally implemented by a Provider whose role chain does not identify
+// its credentials alone, e.g. because an alias selects seed credentials from the environment.
+type ChainCacheKeyer interface {
+	// CacheRoleChain returns the value of cache.Key.Role used to cache the chain's credentials.
+	CacheRoleChain(chain string) (string, error)
 }
 
 type Mixin struct {
 	Ctx context.Context
 
-	CacheDir  string
-	CacheSkip bool   `usage:"Skip reading from cache (but still write after success)"`
-	MfaSerial string `usage:"MFA serial ARN"`
-	MfaSource string `usage:"MFA source (to read from an environment variable, provide the variable's name)"`
+	// Cache stores the credentials between runs.
+	//
+	// If nil, the Credentials method will initialize it based on CacheBackend.
+	Cache cache.Backend
+
+	CacheBackend string `usage:"Cache storage: file, keyring (Linux only), or memory"`
+	CacheDir     string
+	CacheSkip    bool   `usage:"Skip reading from cache (but still write after success)"`
+	MfaSerial    string `usage:"MFA serial ARN"`
+	MfaSource    string `usage:"MFA source (to read from an environment variable, provide the variable's name)"`
+	MfaCommand   string `usage:"Shell command which prints the MFA code, e.g. for runs without a terminal"`
+	Region       string `usage:"Region of the AWS service endpoints, e.g. us-west-2, which is also provided to the command"`
+
+	// NonInteractive disables the MFA prompt, e.g. when an SDK runs the process
+	// as a credential_process, so that [--mfa-source] or [--mfa-command] must provide codes.
+	NonInteractive bool
+
+	// MfaSession is passed to providers, e.g. so several role chains with the same seed prompt for
+	// an MFA code once.
+	MfaSession bool
 
 	// Normally this would live in the cli/handler/mixin/aws/auth/role mixin, but it's
 	// needed earlier than the Provider.Get call for the cache read (key).
@@ -61,10 +130,36 @@ type Mixin struct {
 
 	SessionTtlSec int `usage:"Session length in seconds"`
 
+	MinRemainingSec   int  `usage:"Refuse cached credentials which expire in fewer seconds"`
+	RefreshBeforeSec  int  `usage:"Renew cached credentials which expire in fewer seconds"`
+	RefreshBackground bool `usage:"Use cached credentials selected by [--refresh-before] for this run and renew them in the background"`
+
+	// PromptOut receives the MFA prompt. If nil, os.Stdout is used.
+	//
+	// It allows modes which write data to standard output, e.g. printing the credentials,
+	// to keep the prompt out of the data.
+	PromptOut io.Writer
+
 	// RoleChainFlag is the CLI flag for the RoleChain field.
 	//
 	// It defaults to "role".
 	RoleChainFlag string
+
+	// mfaMu serializes reads of an MFA code, e.g. by renewals which run while the command does,
+	// and guards mfaCodeRead.
+	mfaMu sync.Mutex
+
+	// mfaCodeRead holds the code read by mfaCode.
+	mfaCodeRead string
+
+	// refreshMu guards refreshDone and refreshErr.
+	refreshMu sync.Mutex
+
+	// refreshDone is closed when the background refresh, if any, finishes.
+	refreshDone chan struct{}
+
+	// refreshErr holds the result of the background refresh after refreshDone is closed.
+	refreshErr error
 }
 
 // Implements cage/cli/handler.Mixin
@@ -74,12 +169,18 @@ func (m *Mixin) BindCobraFlags(cmd *cobra.Command) []string {
 		roleChainFlag = "role"
 	}
 
+	cmd.Flags().StringVarP(&m.CacheBackend, "cache-backend", "", cache.BackendFile, cage_reflect.GetFieldTag(m, "CacheBackend", "usage"))
 	cmd.Flags().StringVarP(&m.CacheDir, "cache-dir", "", "", "Defaults to ~/"+defaultHomeCacheDir)
-	cmd.Flags().BoolVarP(&m.CacheSkip, "cache-skip", "", false, cage_reflect.GetFieldTag(*m, "CacheSkip", "usage"))
-	cmd.Flags().StringVarP(&m.MfaSerial, "mfa-serial", "", "", cage_reflect.GetFieldTag(*m, "MfaSerial", "usage"))
-	cmd.Flags().StringVarP(&m.MfaSource, "mfa-source", "", DefaultMfaSource, cage_reflect.GetFieldTag(*m, "MfaSource", "usage"))
-	cmd.Flags().StringVarP(&m.RoleChain, roleChainFlag, "", "", cage_reflect.GetFieldTag(*m, "RoleChain", "usage"))
-	cmd.Flags().IntVarP(&m.SessionTtlSec, "session-ttl", "", DefaultSessionTtlSec, cage_reflect.GetFieldTag(*m, "SessionTtlSec", "usage"))
+	cmd.Flags().BoolVarP(&m.CacheSkip, "cache-skip", "", false, cage_reflect.GetFieldTag(m, "CacheSkip", "usage"))
+	cmd.Flags().StringVarP(&m.MfaSerial, "mfa-serial", "", "", cage_reflect.GetFieldTag(m, "MfaSerial", "usage"))
+	cmd.Flags().StringVarP(&m.MfaSource, "mfa-source", "", DefaultMfaSource, cage_reflect.GetFieldTag(m, "MfaSource", "usage"))
+	cmd.Flags().StringVarP(&m.MfaCommand, "mfa-command", "", "", cage_reflect.GetFieldTag(m, "MfaCommand", "usage"))
+	cmd.Flags().StringVarP(&m.Region, "region", "", "", cage_reflect.GetFieldTag(m, "Region", "usage"))
+	cmd.Flags().StringVarP(&m.RoleChain, roleChainFlag, "", "", cage_reflect.GetFieldTag(m, "RoleChain", "usage"))
+	cmd.Flags().IntVarP(&m.SessionTtlSec, "session-ttl", "", DefaultSessionTtlSec, cage_reflect.GetFieldTag(m, "SessionTtlSec", "usage"))
+	cmd.Flags().IntVarP(&m.MinRemainingSec, "min-remaining", "", DefaultMinRemainingSec, cage_reflect.GetFieldTag(m, "MinRemainingSec", "usage"))
+	cmd.Flags().IntVarP(&m.RefreshBeforeSec, "refresh-before", "", 0, cage_reflect.GetFieldTag(m, "RefreshBeforeSec", "usage"))
+	cmd.Flags().BoolVarP(&m.RefreshBackground, "refresh-background", "", false, cage_reflect.GetFieldTag(m, "RefreshBackground", "usage"))
 	return []string{roleChainFlag}
 }
 
@@ -91,85 +192,362 @@ func (m *Mixin) Name() string {
 // Implements cage/cli/handler.PreRun
 func (m *Mixin) PreRun(ctx context.Context, args []string) error {
 	m.Ctx = ctx
+
+	if m.MfaCommand != "" && m.MfaSource != DefaultMfaSource {
+		return errors.New("--mfa-command cannot be combined with --mfa-source")
+	}
+	if m.MinRemainingSec < 0 {
+		return errors.New("--min-remaining cannot be negative")
+	}
+	if m.RefreshBackground && m.RefreshBeforeSec <= m.MinRemainingSec {
+		return errors.New("--refresh-background requires --refresh-before to exceed --min-remaining")
+	}
+
 	return nil
 }
 
-func (m *Mixin) Credentials(provider Provider) (*credentials.Credentials, error) {
-	if m.CacheDir == "" {
+// Implements cage/cli/handler.PostRun
+//
+// It waits for the background refresh, if any, and reports its failure to standard error.
+func (m *Mixin) PostRun(ctx context.Context) {
+	if err := m.WaitRefresh(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to refresh cached credentials in the background: %+v\n", err)
+	}
+}
+
+// WaitRefresh blocks until the background refresh, if any, finishes and returns its error.
+func (m *Mixin) WaitRefresh() error {
+	m.refreshMu.Lock()
+	done := m.refreshDone
+	m.refreshMu.Unlock()
+
+	if done == nil {
+		return nil
+	}
+	<-done
+
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+	return m.refreshErr
+}
+
+// InitCache assigns the Cache field, if nil, based on CacheBackend and CacheDir.
+func (m *Mixin) InitCache() error {
+	if m.Cache != nil {
+		return nil
+	}
+
+	if m.CacheDir == "" && (m.CacheBackend == "" || m.CacheBackend == cache.BackendFile) {
 		homeDir, homeErr := homedir.Dir()
 		if homeErr != nil {
-			return nil, errors.Wrapf(homeErr, "failed to detect home dir for use as default --cache-dir")
+			return errors.Wrapf(homeErr, "failed to detect home dir for use as default --cache-dir")
 		}
 		m.CacheDir = filepath.Join(homeDir, defaultHomeCacheDir)
 	}
 
-	var mfaCode string
-	if m.MfaSerial != "" {
-		if m.MfaSource == DefaultMfaSource {
-			mfaCodeBytes, promptErr := terminal.DefaultProvider{}.PromptHiddenf("MFA token:")
-			if promptErr != nil {
-				log.Fatalf("failed to read MFA token: %+v", promptErr)
-				return nil, errors.Wrap(promptErr, "failed to MFA token from prompt")
-			}
-			mfaCode = string(mfaCodeBytes)
-		} else {
-			mfaCode = os.Getenv(m.MfaSource)
-		}
+	backend, backendErr := cache.NewBackend(m.CacheBackend, m.CacheDir)
+	if backendErr != nil {
+		return errors.WithStack(backendErr)
+	}
+	m.Cache = backend
+
+	return nil
+}
+
+// Credentials returns cached credentials, if they expire in at least MinRemainingSec,
+// or acquires new ones from the provider and caches them.
+//
+// Cached credentials which expire in less than RefreshBeforeSec are renewed before
+// they are returned, or in the background if RefreshBackground is enabled.
+func (m *Mixin) Credentials(provider Provider) (*credentials.Credentials, error) {
+	return m.CredentialsMinRemaining(provider, 0)
+}
+
+// CredentialsMinRemaining behaves the same as Credentials except that the returned credentials
+// expire in at least the given duration, or MinRemainingSec if longer.
+//
+// It supports callers which renew credentials for a running command, e.g. shortly before
+// the previous ones expire.
+func (m *Mixin) CredentialsMinRemaining(provider Provider, minRemaining time.Duration) (*credentials.Credentials, error) {
+	res, err := m.CredentialsResult(provider, minRemaining)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return res.Creds, nil
+}
+
+// CredentialsResult behaves the same as CredentialsMinRemaining but also returns details
+// about the credentials.
+func (m *Mixin) CredentialsResult(provider Provider, minRemaining time.Duration) (Result, error) {
+	return m.credentialsResult(provider, minRemaining, m.mfaCode)
+}
+
+// RenewCredentials behaves the same as CredentialsMinRemaining except that it never prompts
+// for an MFA code, e.g. because a running command owns the terminal. If a code is needed,
+// it must be provided by [--mfa-command] or [--mfa-source].
+func (m *Mixin) RenewCredentials(provider Provider, minRemaining time.Duration) (*credentials.Credentials, error) {
+	mfaCode := func() (string, error) {
+		return m.readMfaCode("while the command runs")
+	}
+
+	res, err := m.credentialsResult(provider, minRemaining, mfaCode)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return res.Creds, nil
+}
+
+// credentialsResult implements CredentialsResult with an MFA code source.
+func (m *Mixin) credentialsResult(provider Provider, minRemaining time.Duration, mfaCode func() (string, error)) (Result, error) {
+	if configured := time.Duration(m.MinRemainingSec) * time.Second; configured > minRemaining {
+		minRemaining = configured
+	}
+
+	// Prompt at most once per call but not reuse a code across calls, which may be
+	// far enough apart that the code has expired.
+	m.mfaMu.Lock()
+	m.mfaCodeRead = ""
+	m.mfaMu.Unlock()
+
+	if cacheErr := m.InitCache(); cacheErr != nil {
+		return Result{}, errors.WithStack(cacheErr)
 	}
 
 	var cacheVal cache.Value
 
-	cacheStore := cache.NewStore(m.CacheDir)
-	cacheKey := cache.Key{
-		MfaSerial: m.MfaSerial,
-		Role:      m.RoleChain,
+	cacheKey, keyErr := m.cacheKey(provider)
+	if keyErr != nil {
+		return Result{}, errors.WithStack(keyErr)
 	}
 
 	if !m.CacheSkip {
 		var readErr error
-		cacheVal, readErr = cacheStore.Read(cacheKey)
+		cacheVal, readErr = m.Cache.Read(cacheKey)
 		if readErr != nil {
-			return nil, errors.Wrapf(readErr, "failed to read cache key [%s]", cacheKey)
+			return Result{}, errors.Wrapf(readErr, "failed to read cache key [%s]", cacheKey)
 		}
 	}
 
-	var creds *credentials.Credentials
+	if cacheVal.AccessKeyID != "" {
+		remaining := time.Until(time.Unix(cacheVal.Expires, 0))
+
+		switch {
+		case remaining < minRemaining:
+			cacheVal = cache.Value{}
+		case remaining < time.Duration(m.RefreshBeforeSec)*time.Second:
+			if m.RefreshBackground {
+				m.refreshInBackground(provider)
+			} else {
+				cacheVal = cache.Value{}
+			}
+		}
+	}
 
-	if cacheVal.AccessKeyID == "" {
-		var providerErr error
-		creds, providerErr = provider.Get(ProviderInput{
-			Ctx:           m.Ctx,
-			MfaSerial:     m.MfaSerial,
-			MfaCode:       mfaCode,
-			RoleChain:     m.RoleChain,
-			SessionTtlSec: m.SessionTtlSec,
-		})
-		if providerErr != nil {
-			return nil, errors.Wrap(providerErr, "failed to get credentials provider")
+	if cacheVal.AccessKeyID != "" {
+		v := credentials.Value{
+			AccessKeyID:     cacheVal.AccessKeyID,
+			SecretAccessKey: cacheVal.SecretAccessKey,
+			SessionToken:    cacheVal.SessionToken,
 		}
 
-		credsVal, credsErr := creds.Get()
-		if credsErr != nil {
-			return nil, errors.Wrap(credsErr, "failed to get credentials value")
+		var creds *credentials.Credentials
+		if cacheVal.ExpiresUnknown {
+			creds = credentials.NewStaticCredentialsFromCreds(v)
+		} else {
+			creds = expiring.NewCredentials(v, time.Unix(cacheVal.Expires, 0))
+		}
+		return Result{Creds: creds, Arn: cacheVal.Arn, Cached: true}, nil
+	}
+
+	res, acquireErr := m.acquire(provider, mfaCode, minRemaining)
+	if acquireErr != nil {
+		return Result{}, errors.WithStack(acquireErr)
+	}
+
+	return res, nil
+}
+
+// acquire gets credentials from the provider and writes them to the cache.
+//
+// It returns an error if the credentials expire in less than minRemaining.
+func (m *Mixin) acquire(provider Provider, mfaCode func() (string, error), minRemaining time.Duration) (Result, error) {
+	res, providerErr := provider.Get(ProviderInput{
+		Ctx:           m.Ctx,
+		Cache:         m.Cache,
+		CacheSkip:     m.CacheSkip,
+		MinRemaining:  m.refreshThreshold(minRemaining),
+		MfaSerial:     m.MfaSerial,
+		MfaCode:       mfaCode,
+		MfaSession:    m.MfaSession,
+		Region:        m.Region,
+		RoleChain:     m.RoleChain,
+		SessionTtlSec: m.SessionTtlSec,
+	})
+	if providerErr != nil {
+		return Result{}, errors.Wrap(providerErr, "failed to get credentials provider")
+	}
+
+	creds := res.Creds
+
+	credsVal, credsErr := creds.Get()
+	if credsErr != nil {
+		return Result{}, errors.Wrap(credsErr, "failed to get credentials value")
+	}
+
+	// If the provider does not report the expiration, e.g. for a role chain with only a seed alias,
+	// the session TTL only limits the cache entry's lifetime. The credentials do not report it
+	// because they may expire earlier.
+	expires, ok := expiring.ExpiresAt(creds)
+	if !ok {
+		expires = time.Now().Add(time.Duration(m.SessionTtlSec) * time.Second)
+	}
+
+	if remaining := time.Until(expires); ok && remaining < minRemaining {
+		return Result{}, errors.Errorf(
+			"new credentials expire in [%s] which is less than the minimum [%s] (see --min-remaining)",
+			remaining.Round(time.Second), minRemaining,
+		)
+	}
+
+	cacheKey, keyErr := m.cacheKey(provider)
+	if keyErr != nil {
+		return Result{}, errors.WithStack(keyErr)
+	}
+	writeErr := m.Cache.Write(cacheKey, cache.Value{
+		AccessKeyID:     credsVal.AccessKeyID,
+		SecretAccessKey: credsVal.SecretAccessKey,
+		SessionToken:    credsVal.SessionToken,
+		Expires:         expires.Unix(),
+		ExpiresUnknown:  !ok,
+		Arn:             res.Arn,
+	})
+	if writeErr != nil {
+		return Result{}, errors.Wrapf(writeErr, "failed to write cache key [%s]", cacheKey)
+	}
+
+	return res, nil
+}
+
+// refreshInBackground starts a goroutine which acquires new credentials and caches them.
+//
+// It does not prompt for an MFA code because the prompt would compete with the command
+// for the terminal. Use WaitRefresh to collect the result.
+func (m *Mixin) refreshInBackground(provider Provider) {
+	m.refreshMu.Lock()
+	defer m.refreshMu.Unlock()
+
+	if m.refreshDone != nil {
+		select {
+		case <-m.refreshDone:
+		default:
+			return // already in progress
 		}
+	}
+
+	done := make(chan struct{})
+	m.refreshDone = done
+
+	mfaCode := func() (string, error) {
+		return m.readMfaCode("during a background refresh")
+	}
+
+	go func() {
+		_, err := m.acquire(provider, mfaCode, time.Duration(m.MinRemainingSec)*time.Second)
 
-		cacheVal = cache.Value{
-			AccessKeyID:     credsVal.AccessKeyID,
-			SecretAccessKey: credsVal.SecretAccessKey,
-			SessionToken:    credsVal.SessionToken,
-			Expires:         time.Now().Add(time.Duration(m.SessionTtlSec-cacheEarlyTtlSec) * time.Second).Unix(),
+		m.refreshMu.Lock()
+		m.refreshErr = err
+		m.refreshMu.Unlock()
+
+		close(done)
+	}()
+}
+
+// refreshThreshold returns the shortest lifetime of cached credentials which will not be renewed.
+func (m *Mixin) refreshThreshold(minRemaining time.Duration) time.Duration {
+	if refreshBefore := time.Duration(m.RefreshBeforeSec) * time.Second; refreshBefore > minRemaining {
+		return refreshBefore
+	}
+	return minRemaining
+}
+
+func (m *Mixin) cacheKey(provider Provider) (cache.Key, error) {
+	role := m.RoleChain
+	switch keyer := provider.(type) {
+	case CacheKeyer:
+		role = keyer.CacheRole()
+	case ChainCacheKeyer:
+		var keyErr error
+		if role, keyErr = keyer.CacheRoleChain(m.RoleChain); keyErr != nil {
+			return cache.Key{}, errors.Wrap(keyErr, "failed to identify the credentials in the cache")
 		}
+	}
+	return cache.Key{
+		MfaSerial: m.MfaSerial,
+		Role:      role,
+	}, nil
+}
+
+// mfaCode reads a code from the MFA source.
+//
+// The code is retained so that multiple providers, or multiple calls from one provider,
+// do not prompt more than once.
+func (m *Mixin) mfaCode() (string, error) {
+	m.mfaMu.Lock()
+	defer m.mfaMu.Unlock()
+
+	if m.mfaCodeRead != "" {
+		return m.mfaCodeRead, nil
+	}
+
+	var noPrompt string
+	if m.NonInteractive {
+		noPrompt = "in non-interactive mode"
+	}
+
+	code, err := m.readMfaCode(noPrompt)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	m.mfaCodeRead = code
+
+	return m.mfaCodeRead, nil
+}
+
+// readMfaCode reads a code from [--mfa-command], the [--mfa-source] variable, or a prompt.
+//
+// If noPrompt is non-empty, it describes why a prompt is unavailable, and an error is
+// returned instead of prompting.
+func (m *Mixin) readMfaCode(noPrompt string) (string, error) {
+	if m.MfaCommand != "" {
+		var stdout bytes.Buffer
 
-		writeErr := cacheStore.Write(cacheKey, cacheVal)
-		if writeErr != nil {
-			return nil, errors.Wrapf(writeErr, "failed to write cache key [%s]", cacheKey)
+		cmd := exec.Command("sh", "-c", m.MfaCommand) // #nosec
+		cmd.Stdout = &stdout
+		cmd.Stderr = os.Stderr // e.g. a "touch your security key" message
+
+		if runErr := cmd.Run(); runErr != nil {
+			return "", errors.Wrapf(runErr, "failed to read MFA token from command [%s]", m.MfaCommand)
 		}
-	} else {
-		creds = credentials.NewStaticCredentials(cacheVal.AccessKeyID, cacheVal.SecretAccessKey, cacheVal.SessionToken)
+
+		return strings.TrimSpace(stdout.String()), nil
+	}
+
+	if m.MfaSource != DefaultMfaSource {
+		return os.Getenv(m.MfaSource), nil
+	}
+
+	if noPrompt != "" {
+		return "", errors.Errorf("an MFA prompt is not supported %s (use --mfa-command or --mfa-source)", noPrompt)
+	}
+
+	code, promptErr := terminal.DefaultProvider{Out: m.PromptOut}.PromptHiddenf("MFA token:")
+	if promptErr != nil {
+		return "", errors.Wrap(promptErr, "failed to read MFA token from prompt")
 	}
 
-	return creds, nil
+	return code, nil
 }
 
 var _ handler.Mixin = (*Mixin)(nil)
 var _ handler.PreRun = (*Mixin)(nil)
+var _ handler.PostRun = (*Mixin)(nil)
@@ -0,0 +1,275 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package auth_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/cache"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+)
+
+// provider returns new credentials, which expire after ttl, on every call.
+type provider struct {
+	calls int32
+	ttl   time.Duration
+}
+
+func (p *provider) Get(input auth.ProviderInput) (auth.Result, error) {
+	n := atomic.AddInt32(&p.calls, 1)
+	creds := expiring.NewCredentials(
+		credentials.Value{AccessKeyID: fmt.Sprintf("id%d", n), SecretAccessKey: "secret", SessionToken: "token"},
+		time.Now().Add(p.ttl),
+	)
+	return auth.Result{Creds: creds, Arn: fmt.Sprintf("arn:aws:sts::123456789012:assumed-role/r/s%d", n)}, nil
+}
+
+// chainProvider identifies its chain's seed credentials by seed in cache keys.
+type chainProvider struct {
+	provider
+	seed string
+}
+
+func (p *chainProvider) CacheRoleChain(chain string) (string, error) {
+	return chain + "@" + p.seed, nil
+}
+
+// staticProvider returns credentials which do not report an expiration.
+type staticProvider struct{}
+
+func (p *staticProvider) Get(input auth.ProviderInput) (auth.Result, error) {
+	return auth.Result{Creds: credentials.NewStaticCredentials("static", "secret", "token")}, nil
+}
+
+func newMixin(c cache.Backend) *auth.Mixin {
+	return &auth.Mixin{
+		Ctx:             context.Background(),
+		Cache:           c,
+		RoleChain:       "instance",
+		SessionTtlSec:   auth.DefaultSessionTtlSec,
+		MinRemainingSec: auth.DefaultMinRemainingSec,
+	}
+}
+
+func requireAccessKeyID(t *testing.T, expected string, creds *credentials.Credentials) {
+	v, err := creds.Get()
+	require.NoError(t, err)
+	require.Exactly(t, expected, v.AccessKeyID)
+}
+
+func TestCredentials(t *testing.T) {
+	t.Run("should read from cache", func(t *testing.T) {
+		p := &provider{ttl: time.Hour}
+		m := newMixin(cache.NewMemory())
+
+		creds, err := m.Credentials(p)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "id1", creds)
+
+		creds, err = m.Credentials(p)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "id1", creds)
+
+		expires, ok := expiring.ExpiresAt(creds)
+		require.True(t, ok)
+		require.WithinDuration(t, time.Now().Add(time.Hour), expires, 5*time.Second)
+
+		res, err := m.CredentialsResult(p, 0)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "id1", res.Creds)
+		require.Exactly(t, "arn:aws:sts::123456789012:assumed-role/r/s1", res.Arn)
+	})
+
+	t.Run("should report whether the cache was hit", func(t *testing.T) {
+		p := &provider{ttl: time.Hour}
+		m := newMixin(cache.NewMemory())
+
+		res, err := m.CredentialsResult(p, 0)
+		require.NoError(t, err)
+		require.False(t, res.Cached)
+
+		res, err = m.CredentialsResult(p, 0)
+		require.NoError(t, err)
+		require.True(t, res.Cached)
+		requireAccessKeyID(t, "id1", res.Creds)
+	})
+
+	t.Run("should key the cache by the provider's chain identity", func(t *testing.T) {
+		p := &chainProvider{provider: provider{ttl: time.Hour}, seed: "a"}
+		m := newMixin(cache.NewMemory())
+
+		creds, err := m.Credentials(p)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "id1", creds)
+
+		p.seed = "b"
+
+		creds, err = m.Credentials(p)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "id2", creds)
+
+		p.seed = "a"
+
+		creds, err = m.Credentials(p)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "id1", creds)
+	})
+
+	t.Run("should not report an expiration unknown to the provider", func(t *testing.T) {
+		m := newMixin(cache.NewMemory())
+
+		for _, cached := range []bool{false, true} {
+			res, err := m.CredentialsResult(&staticProvider{}, 0)
+			require.NoError(t, err)
+			require.Exactly(t, cached, res.Cached)
+			requireAccessKeyID(t, "static", res.Creds)
+
+			_, ok := expiring.ExpiresAt(res.Creds)
+			require.False(t, ok)
+		}
+	})
+
+	t.Run("should refuse cached credentials below min remaining", func(t *testing.T) {
+		p := &provider{ttl: 30 * time.Minute}
+		m := newMixin(cache.NewMemory())
+
+		_, err := m.Credentials(p)
+		require.NoError(t, err)
+
+		p.ttl = time.Hour
+		m.MinRemainingSec = 45 * 60
+
+		creds, err := m.Credentials(p)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "id2", creds)
+	})
+
+	t.Run("should fail if new credentials are below min remaining", func(t *testing.T) {
+		m := newMixin(cache.NewMemory())
+		m.MinRemainingSec = 2 * 60 * 60
+
+		_, err := m.Credentials(&provider{ttl: time.Hour})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "(see --min-remaining)")
+	})
+
+	t.Run("should refresh before the threshold", func(t *testing.T) {
+		p := &provider{ttl: 30 * time.Minute}
+		m := newMixin(cache.NewMemory())
+
+		_, err := m.Credentials(p)
+		require.NoError(t, err)
+
+		m.RefreshBeforeSec = 45 * 60
+		p.ttl = time.Hour
+
+		creds, err := m.Credentials(p)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "id2", creds)
+		require.NoError(t, m.WaitRefresh())
+	})
+
+	t.Run("should refresh in the background", func(t *testing.T) {
+		p := &provider{ttl: 30 * time.Minute}
+		m := newMixin(cache.NewMemory())
+
+		_, err := m.Credentials(p)
+		require.NoError(t, err)
+
+		m.RefreshBeforeSec = 45 * 60
+		m.RefreshBackground = true
+		p.ttl = time.Hour
+
+		creds, err := m.Credentials(p)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "id1", creds)
+		require.NoError(t, m.WaitRefresh())
+
+		creds, err = m.Credentials(p)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "id2", creds)
+	})
+}
+
+// mfaProvider returns credentials whose session token is the MFA code.
+type mfaProvider struct{}
+
+func (p *mfaProvider) Get(input auth.ProviderInput) (auth.Result, error) {
+	code, err := input.MfaCode()
+	if err != nil {
+		return auth.Result{}, err
+	}
+	creds := expiring.NewCredentials(
+		credentials.Value{AccessKeyID: "id", SecretAccessKey: "secret", SessionToken: code},
+		time.Now().Add(time.Hour),
+	)
+	return auth.Result{Creds: creds}, nil
+}
+
+func TestMfaCode(t *testing.T) {
+	t.Run("should read from command", func(t *testing.T) {
+		m := newMixin(cache.NewMemory())
+		m.MfaSource = auth.DefaultMfaSource
+		m.MfaCommand = "echo ' 123456 '"
+		m.NonInteractive = true
+
+		creds, err := m.Credentials(&mfaProvider{})
+		require.NoError(t, err)
+		v, err := creds.Get()
+		require.NoError(t, err)
+		require.Exactly(t, "123456", v.SessionToken)
+	})
+
+	t.Run("should refuse to prompt in non-interactive mode", func(t *testing.T) {
+		m := newMixin(cache.NewMemory())
+		m.MfaSource = auth.DefaultMfaSource
+		m.NonInteractive = true
+
+		_, err := m.Credentials(&mfaProvider{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "non-interactive")
+	})
+
+	t.Run("should refuse to prompt for a renewal", func(t *testing.T) {
+		m := newMixin(cache.NewMemory())
+		m.MfaSource = auth.DefaultMfaSource
+
+		_, err := m.RenewCredentials(&mfaProvider{}, 0)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "while the command runs (use --mfa-command")
+	})
+
+	t.Run("should read from command for a renewal", func(t *testing.T) {
+		m := newMixin(cache.NewMemory())
+		m.MfaSource = auth.DefaultMfaSource
+		m.MfaCommand = "echo 123456"
+
+		creds, err := m.RenewCredentials(&mfaProvider{}, 0)
+		require.NoError(t, err)
+		v, err := creds.Get()
+		require.NoError(t, err)
+		require.Exactly(t, "123456", v.SessionToken)
+	})
+
+	t.Run("should report command failure", func(t *testing.T) {
+		m := newMixin(cache.NewMemory())
+		m.MfaSource = auth.DefaultMfaSource
+		m.MfaCommand = "exit 3"
+
+		_, err := m.Credentials(&mfaProvider{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "exit 3")
+	})
+}
@@ -8,23 +8,40 @@ package role
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cognitoidentity"
+	"github.com/aws/aws-sdk-go/service/cognitoidentity/cognitoidentityiface"
 	"github.com/pkg/errors"
 
 	"github.com/spf13/cobra"
 
 	cage_aws "github.com/codeactual/aws-exec-cmd/internal/cage/aws"
 	cage_cognito_core "github.com/codeactual/aws-exec-cmd/internal/cage/aws/cognito"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/cache"
 	cage_cognito "github.com/codeactual/aws-exec-cmd/internal/cage/aws/v1/cognito"
 	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler"
 	handler_aws_auth "github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
 	google_auth "github.com/codeactual/aws-exec-cmd/internal/cage/google/auth"
 )
 
+const (
+	// idTokenMinRemaining reduces the opportunity for a cached ID token to expire before
+	// the pool receives it.
+	idTokenMinRemaining = time.Minute
+
+	// identityIdCacheTtl bounds how long an identity ID is reused. The ID does not change
+	// for a login, so this only limits how long a stale entry could linger.
+	identityIdCacheTtl = 30 * 24 * time.Hour
+)
+
 type Mixin struct {
 	ClientId             string
 	ClientSecret         string
@@ -33,6 +50,12 @@ type Mixin struct {
 	IdentityPoolId  string
 	ProviderIdToken string
 	ProviderName    string
+
+	// Client, if non-nil, replaces the Cognito client of the region, e.g. in tests.
+	Client cognitoidentityiface.CognitoIdentityAPI
+
+	// RequestRefresh, if non-nil, replaces google/auth.RequestRefresh, e.g. in tests.
+	RequestRefresh func(ctx context.Context, clientId, clientSecret, refreshToken string) (google_auth.RefreshResponse, error)
 }
 
 // Implements cage/cli/handler.Mixin
@@ -81,39 +104,193 @@ func (m *Mixin) PreRun(ctx context.Context, args []string) error {
 	return nil
 }
 
-func (m *Mixin) Get(input handler_aws_auth.ProviderInput) (*credentials.Credentials, error) {
-	region := cage_aws.GetenvRegion()
+// CacheRole returns a cache key role which identifies the pool, provider, and login.
+//
+// Tokens are hashed because cache backends may record keys in plaintext.
+//
+// Implements cage/cli/handler/mixin/aws/auth.CacheKeyer
+func (m *Mixin) CacheRole() string {
+	login := m.ProviderIdToken
+	if login == "" {
+		login = m.ClientId + "," + m.ProviderRefreshToken
+	}
+	return fmt.Sprintf("idp:%s,%s,%s", m.IdentityPoolId, m.ProviderName, hashString(login))
+}
+
+// Implements cage/cli/handler/mixin/aws/auth.Provider
+//
+// If input.Cache is non-nil, the ID token acquired with --refresh and the identity ID are cached
+// so that later runs only need to request the credentials. If the pool rejects cached data,
+// it is removed and the login is retried without it.
+func (m *Mixin) Get(input handler_aws_auth.ProviderInput) (handler_aws_auth.Result, error) {
+	svc := m.Client
+	if svc == nil {
+		region := input.Region
+		if region == "" {
+			region = cage_aws.GetenvRegion()
+		}
+
+		sess, err := session.NewSession(&aws.Config{
+			Region: aws.String(region),
+		})
+
+		if err != nil {
+			return handler_aws_auth.Result{}, errors.Wrapf(err, "failed to create a new session for region [%s]", region)
+		}
 
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	})
+		svc = cognitoidentity.New(sess)
+	}
 
+	creds, usedCache, err := m.login(input, svc, !input.CacheSkip)
+	if err != nil && usedCache && cage_cognito.IsNotAuthorized(err) {
+		if invalidateErr := m.invalidate(input); invalidateErr != nil {
+			return handler_aws_auth.Result{}, errors.WithStack(invalidateErr)
+		}
+		creds, _, err = m.login(input, svc, false)
+	}
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to create a new session for region [%s]", region)
+		return handler_aws_auth.Result{}, errors.WithStack(err)
 	}
 
-	svc := cognitoidentity.New(sess)
+	return handler_aws_auth.Result{Creds: creds}, nil
+}
+
+// login requests credentials and optionally reads the ID token and identity ID from the cache.
+//
+// It returns usedCache as true if any cached data was used in the request.
+func (m *Mixin) login(input handler_aws_auth.ProviderInput, svc cognitoidentityiface.CognitoIdentityAPI, readCache bool) (creds *credentials.Credentials, usedCache bool, err error) {
+	if input.Cache == nil {
+		readCache = false
+	}
 
 	var idToken string
 	if m.ProviderIdToken == "" {
-		refresh, refreshErr := google_auth.RequestRefresh(input.Ctx, m.ClientId, m.ClientSecret, m.ProviderRefreshToken)
-		if refreshErr != nil {
-			return nil, errors.Wrap(refreshErr, "failed to request refresh")
+		var cachedToken bool
+		idToken, cachedToken, err = m.idToken(input, readCache)
+		if err != nil {
+			return nil, false, errors.WithStack(err)
 		}
-
-		idToken = refresh.IDToken
+		usedCache = cachedToken
 	} else {
 		idToken = m.ProviderIdToken
 	}
 
-	res, err := cage_cognito.SingleIdentityLogin(svc, m.IdentityPoolId, m.ProviderName, idToken)
+	var identityKey cache.Key
+	var identityId string
+
+	if input.Cache != nil {
+		subject, subjectErr := google_auth.IDTokenSubject(idToken)
+		if subjectErr == nil { // otherwise skip the cache, e.g. if the token is not a JWT
+			identityKey = cache.Key{Role: fmt.Sprintf("idp-identity-id:%s,%s,%s", m.IdentityPoolId, m.ProviderName, subject)}
+		}
+	}
+
+	if readCache && identityKey.Role != "" {
+		cached, readErr := input.Cache.Read(identityKey)
+		if readErr != nil {
+			return nil, usedCache, errors.Wrapf(readErr, "failed to read cache key [%s]", identityKey)
+		}
+		if cached.Data != "" {
+			identityId = cached.Data
+			usedCache = true
+		}
+	}
+
+	if identityId == "" {
+		identityId, err = cage_cognito.GetId(svc, m.IdentityPoolId, m.ProviderName, idToken)
+		if err != nil {
+			return nil, usedCache, errors.Wrap(err, "failed to request identity ID")
+		}
+
+		if identityKey.Role != "" {
+			writeErr := input.Cache.Write(identityKey, cache.Value{
+				Data:    identityId,
+				Expires: time.Now().Add(identityIdCacheTtl).Unix(),
+			})
+			if writeErr != nil {
+				return nil, usedCache, errors.Wrapf(writeErr, "failed to write cache key [%s]", identityKey)
+			}
+		}
+	}
+
+	res, err := cage_cognito.GetCredentialsForIdentity(svc, identityId, m.IdentityPoolId, m.ProviderName, idToken)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to request credentials")
+		return nil, usedCache, errors.Wrap(err, "failed to request credentials")
+	}
+
+	return res.Creds, usedCache, nil
+}
+
+// idToken returns an ID token from the cache, or one acquired with the refresh token.
+func (m *Mixin) idToken(input handler_aws_auth.ProviderInput, readCache bool) (idToken string, cached bool, err error) {
+	k := m.idTokenCacheKey()
+
+	if readCache {
+		v, readErr := input.Cache.Read(k)
+		if readErr != nil {
+			return "", false, errors.Wrapf(readErr, "failed to read cache key [%s]", k)
+		}
+		if v.Data != "" && time.Until(time.Unix(v.Expires, 0)) >= idTokenMinRemaining {
+			return v.Data, true, nil
+		}
+	}
+
+	requestRefresh := m.RequestRefresh
+	if requestRefresh == nil {
+		requestRefresh = google_auth.RequestRefresh
+	}
+
+	refresh, refreshErr := requestRefresh(input.Ctx, m.ClientId, m.ClientSecret, m.ProviderRefreshToken)
+	if refreshErr != nil {
+		return "", false, errors.Wrap(refreshErr, "failed to request refresh")
+	}
+
+	if input.Cache != nil && refresh.ExpiresIn > 0 {
+		writeErr := input.Cache.Write(k, cache.Value{
+			Data:    refresh.IDToken,
+			Expires: time.Now().Add(time.Duration(refresh.ExpiresIn) * time.Second).Unix(),
+		})
+		if writeErr != nil {
+			return "", false, errors.Wrapf(writeErr, "failed to write cache key [%s]", k)
+		}
+	}
+
+	return refresh.IDToken, false, nil
+}
+
+// invalidate removes the cached ID token and all cached identity IDs of the pool.
+func (m *Mixin) invalidate(input handler_aws_auth.ProviderInput) error {
+	if err := input.Cache.Delete(m.idTokenCacheKey()); err != nil {
+		return errors.Wrap(err, "failed to remove cached ID token")
+	}
+
+	keys, listErr := input.Cache.List()
+	if listErr != nil {
+		return errors.Wrap(listErr, "failed to list cache keys")
 	}
 
-	return res.Creds, nil
+	prefix := fmt.Sprintf("idp-identity-id:%s,%s,", m.IdentityPoolId, m.ProviderName)
+	for _, k := range keys {
+		if strings.HasPrefix(k.Role, prefix) {
+			if err := input.Cache.Delete(k); err != nil {
+				return errors.Wrapf(err, "failed to remove cached identity ID [%s]", k)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Mixin) idTokenCacheKey() cache.Key {
+	return cache.Key{Role: fmt.Sprintf("idp-id-token:%s,%s", m.ProviderName, hashString(m.ClientId+","+m.ProviderRefreshToken))}
+}
+
+func hashString(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(hash[:])
 }
 
 var _ handler.Mixin = (*Mixin)(nil)
 var _ handler.PreRun = (*Mixin)(nil)
 var _ handler_aws_auth.Provider = (*Mixin)(nil)
+var _ handler_aws_auth.CacheKeyer = (*Mixin)(nil)
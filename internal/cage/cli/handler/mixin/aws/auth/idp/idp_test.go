@@ -0,0 +1,172 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package role_test
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cognitoidentity"
+	"github.com/aws/aws-sdk-go/service/cognitoidentity/cognitoidentityiface"
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/cache"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+	auth_idp "github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth/idp"
+	google_auth "github.com/codeactual/aws-exec-cmd/internal/cage/google/auth"
+)
+
+// idToken is a JWT whose subject identifies the user.
+var idToken = "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1234"}`)) + ".sig"
+
+// client stubs the Cognito operations used by the provider.
+type client struct {
+	cognitoidentityiface.CognitoIdentityAPI
+
+	// identityId is returned by GetId.
+	identityId string
+
+	// rejectId is an identity ID which GetCredentialsForIdentity rejects as not authorized.
+	rejectId string
+
+	getIdCalls, getCredsCalls int
+}
+
+func (c *client) GetId(input *cognitoidentity.GetIdInput) (*cognitoidentity.GetIdOutput, error) {
+	c.getIdCalls++
+	return &cognitoidentity.GetIdOutput{IdentityId: aws.String(c.identityId)}, nil
+}
+
+func (c *client) GetCredentialsForIdentity(input *cognitoidentity.GetCredentialsForIdentityInput) (*cognitoidentity.GetCredentialsForIdentityOutput, error) {
+	c.getCredsCalls++
+	if aws.StringValue(input.IdentityId) == c.rejectId {
+		return nil, awserr.New(cognitoidentity.ErrCodeNotAuthorizedException, "invalid login token", nil)
+	}
+	return &cognitoidentity.GetCredentialsForIdentityOutput{
+		IdentityId: input.IdentityId,
+		Credentials: &cognitoidentity.Credentials{
+			AccessKeyId:  aws.String("key-" + aws.StringValue(input.IdentityId)),
+			SecretKey:    aws.String("secret"),
+			SessionToken: aws.String("token"),
+			Expiration:   aws.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
+
+// newMixin returns a provider which logs in with a refresh token and counts the refresh requests.
+func newMixin(c *client, expiresIn int, refreshCalls *int) *auth_idp.Mixin {
+	return &auth_idp.Mixin{
+		ClientId:             "client",
+		ClientSecret:         "secret",
+		ProviderRefreshToken: "refresh",
+		IdentityPoolId:       "us-west-2:pool",
+		ProviderName:         "accounts.google.com",
+		Client:               c,
+		RequestRefresh: func(ctx context.Context, clientId, clientSecret, refreshToken string) (google_auth.RefreshResponse, error) {
+			*refreshCalls++
+			return google_auth.RefreshResponse{IDToken: idToken, ExpiresIn: expiresIn}, nil
+		},
+	}
+}
+
+func requireAccessKeyID(t *testing.T, expected string, res auth.Result) {
+	v, err := res.Creds.Get()
+	require.NoError(t, err)
+	require.Exactly(t, expected, v.AccessKeyID)
+}
+
+func TestGet(t *testing.T) {
+	t.Run("should reuse the cached ID token and identity ID", func(t *testing.T) {
+		var refreshCalls int
+		c := &client{identityId: "id-1"}
+		m := newMixin(c, 3600, &refreshCalls)
+		input := auth.ProviderInput{Ctx: context.Background(), Cache: cache.NewMemory()}
+
+		for n := 0; n < 2; n++ {
+			res, err := m.Get(input)
+			require.NoError(t, err)
+			requireAccessKeyID(t, "key-id-1", res)
+		}
+
+		require.Exactly(t, 1, refreshCalls)
+		require.Exactly(t, 1, c.getIdCalls)
+		require.Exactly(t, 2, c.getCredsCalls)
+	})
+
+	t.Run("should not read the cache if skipped", func(t *testing.T) {
+		var refreshCalls int
+		c := &client{identityId: "id-1"}
+		m := newMixin(c, 3600, &refreshCalls)
+		input := auth.ProviderInput{Ctx: context.Background(), Cache: cache.NewMemory()}
+
+		_, err := m.Get(input)
+		require.NoError(t, err)
+
+		input.CacheSkip = true
+		_, err = m.Get(input)
+		require.NoError(t, err)
+
+		require.Exactly(t, 2, refreshCalls)
+		require.Exactly(t, 2, c.getIdCalls)
+	})
+
+	t.Run("should refresh an ID token which expires soon", func(t *testing.T) {
+		var refreshCalls int
+		c := &client{identityId: "id-1"}
+		m := newMixin(c, 30, &refreshCalls)
+		input := auth.ProviderInput{Ctx: context.Background(), Cache: cache.NewMemory()}
+
+		for n := 0; n < 2; n++ {
+			_, err := m.Get(input)
+			require.NoError(t, err)
+		}
+
+		require.Exactly(t, 2, refreshCalls)
+		require.Exactly(t, 1, c.getIdCalls) // the identity ID does not depend on the token's lifetime
+	})
+
+	t.Run("should retry without cached data if not authorized", func(t *testing.T) {
+		var refreshCalls int
+		c := &client{identityId: "id-1"}
+		m := newMixin(c, 3600, &refreshCalls)
+		input := auth.ProviderInput{Ctx: context.Background(), Cache: cache.NewMemory()}
+
+		_, err := m.Get(input)
+		require.NoError(t, err)
+
+		c.identityId = "id-2"
+		c.rejectId = "id-1"
+
+		res, err := m.Get(input)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "key-id-2", res)
+		require.Exactly(t, 2, refreshCalls)
+		require.Exactly(t, 2, c.getIdCalls)
+
+		// The new identity ID replaced the rejected one in the cache.
+		res, err = m.Get(input)
+		require.NoError(t, err)
+		requireAccessKeyID(t, "key-id-2", res)
+		require.Exactly(t, 2, refreshCalls)
+		require.Exactly(t, 2, c.getIdCalls)
+	})
+
+	t.Run("should not retry if no cached data was used", func(t *testing.T) {
+		var refreshCalls int
+		c := &client{identityId: "id-1", rejectId: "id-1"}
+		m := newMixin(c, 3600, &refreshCalls)
+
+		_, err := m.Get(auth.ProviderInput{Ctx: context.Background(), Cache: cache.NewMemory()})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), cognitoidentity.ErrCodeNotAuthorizedException)
+		require.Exactly(t, 1, c.getCredsCalls)
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
 	cage_sts "github.com/codeactual/aws-exec-cmd/internal/cage/aws/v1/sts"
 	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler"
 	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
@@ -36,35 +37,64 @@ func (m *Mixin) Name() string {
 }
 
 // Implements cage/cli/handler/mixin/aws/auth.Provider
-func (m *Mixin) Get(input auth.ProviderInput) (*credentials.Credentials, error) {
-	var parsedRoleChain []string
-	for _, role := range strings.Split(input.RoleChain, ",") {
-		role = strings.TrimSpace(role)
-		if role != "" {
-			parsedRoleChain = append(parsedRoleChain, role)
-		}
-	}
+func (m *Mixin) Get(input auth.ProviderInput) (auth.Result, error) {
+	parsedRoleChain := parseRoleChain(input.RoleChain)
 
 	if len(parsedRoleChain) == 0 {
-		return nil, errors.New("role chain required")
+		return auth.Result{}, errors.New("role chain required")
 	}
 
 	resolveInput := cage_sts.ResolveRoleChainInput{
-		Chain:           parsedRoleChain,
-		DurationSeconds: int64(input.SessionTtlSec),
+		Chain:             parsedRoleChain,
+		DurationSeconds:   int64(input.SessionTtlSec),
+		Cache:             input.Cache,
+		CacheSkip:         input.CacheSkip,
+		CacheMinRemaining: input.MinRemaining,
+		Region:            input.Region,
 	}
 	if input.MfaSerial != "" {
 		resolveInput.SerialNumber = input.MfaSerial
-		resolveInput.TokenCode = input.MfaCode
+		resolveInput.TokenProvider = input.MfaCode
+		resolveInput.MfaSession = input.MfaSession
 	}
 
-	id, secret, token, resolveErr := cage_sts.ResolveRoleChain(&resolveInput)
+	out, resolveErr := cage_sts.ResolveRoleChainDetail(&resolveInput)
 	if resolveErr != nil {
-		return nil, errors.Wrapf(resolveErr, "failed to resolve role chain [%s]", strings.Join(parsedRoleChain, ","))
+		return auth.Result{}, errors.Wrapf(resolveErr, "failed to resolve role chain [%s]", strings.Join(parsedRoleChain, ","))
+	}
+
+	if out.Expiration.IsZero() { // e.g. chain only contained a seed alias
+		creds := credentials.NewStaticCredentials(out.Creds.AccessKeyID, out.Creds.SecretAccessKey, out.Creds.SessionToken)
+		return auth.Result{Creds: creds}, nil
+	}
+
+	return auth.Result{Creds: expiring.NewCredentials(out.Creds, out.Expiration), Arn: out.Arn}, nil
+}
+
+// Implements cage/cli/handler/mixin/aws/auth.ChainCacheKeyer
+//
+// A seed alias, e.g. "env-triple", is replaced by an identifier of its current credentials
+// so that other seed credentials do not reuse the cached chain.
+func (m *Mixin) CacheRoleChain(chain string) (string, error) {
+	cacheChain, err := cage_sts.CacheChain(parseRoleChain(chain))
+	if err != nil {
+		return "", errors.WithStack(err)
 	}
+	return strings.Join(cacheChain, ","), nil
+}
 
-	return credentials.NewStaticCredentials(id, secret, token), nil
+// parseRoleChain returns the non-empty elements of the comma-separated chain.
+func parseRoleChain(chain string) []string {
+	var parsed []string
+	for _, role := range strings.Split(chain, ",") {
+		role = strings.TrimSpace(role)
+		if role != "" {
+			parsed = append(parsed, role)
+		}
+	}
+	return parsed
 }
 
 var _ handler.Mixin = (*Mixin)(nil)
 var _ auth.Provider = (*Mixin)(nil)
+var _ auth.ChainCacheKeyer = (*Mixin)(nil)
@@ -0,0 +1,167 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package dotenv parses files of environment variables in the common dotenv format.
+package dotenv
+
+import (
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// nameRe matches portable environment variable names.
+var nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Parse returns the "KEY=value" pairs of a dotenv file in order of appearance.
+//
+// Supported syntax:
+//
+//   - Blank lines and lines which start with '#' are ignored.
+//   - A line may start with "export ".
+//   - An unquoted value is trimmed and ends before a '#' which follows whitespace.
+//   - A single-quoted value is literal and may span lines.
+//   - A double-quoted value may span lines and supports the \n, \r, \t, \", \\, and \$ escapes.
+//
+// References to other variables, e.g. ${NAME}, are not expanded.
+func Parse(r io.Reader) ([]string, error) {
+	data, readErr := ioutil.ReadAll(r)
+	if readErr != nil {
+		return nil, errors.WithStack(readErr)
+	}
+
+	rest := strings.Replace(string(data), "\r\n", "\n", -1)
+	lineNum := 0
+
+	nextLine := func() string {
+		lineNum++
+		var line string
+		if n := strings.IndexByte(rest, '\n'); n >= 0 {
+			line, rest = rest[:n], rest[n+1:]
+		} else {
+			line, rest = rest, ""
+		}
+		return line
+	}
+
+	var pairs []string
+
+	for rest != "" {
+		line := strings.TrimLeft(nextLine(), " \t")
+		start := lineNum
+
+		if trimmed := strings.TrimSpace(line); trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "export ") || strings.HasPrefix(line, "export\t") {
+			line = strings.TrimLeft(line[len("export"):], " \t")
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, errors.Errorf("line %d: expected NAME=VALUE", start)
+		}
+
+		name := strings.TrimSpace(line[:eq])
+		if !nameRe.MatchString(name) {
+			return nil, errors.Errorf("line %d: invalid variable name [%s]", start, name)
+		}
+
+		value := strings.TrimLeft(line[eq+1:], " \t")
+
+		if value == "" || (value[0] != '\'' && value[0] != '"') {
+			pairs = append(pairs, name+"="+stripComment(value))
+			continue
+		}
+
+		quote := value[0]
+		body := value[1:]
+
+		for {
+			end := closingQuote(body, quote)
+			if end >= 0 {
+				if after := strings.TrimSpace(body[end+1:]); after != "" && after[0] != '#' {
+					return nil, errors.Errorf("line %d: unexpected text after the closing quote of [%s]", lineNum, name)
+				}
+				value = body[:end]
+				break
+			}
+			if rest == "" {
+				return nil, errors.Errorf("line %d: unterminated quoted value of [%s]", start, name)
+			}
+			body += "\n" + nextLine()
+		}
+
+		if quote == '"' {
+			value = unescape(value)
+		}
+
+		pairs = append(pairs, name+"="+value)
+	}
+
+	return pairs, nil
+}
+
+// stripComment returns the trimmed unquoted value without a trailing comment.
+func stripComment(value string) string {
+	for n := 1; n < len(value); n++ {
+		if value[n] == '#' && (value[n-1] == ' ' || value[n-1] == '\t') {
+			value = value[:n]
+			break
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
+// closingQuote returns the index of the quote which ends the value, or -1 if not found.
+//
+// Escaped double quotes do not end a double-quoted value.
+func closingQuote(s string, quote byte) int {
+	if quote == '\'' {
+		return strings.IndexByte(s, quote)
+	}
+	for n := 0; n < len(s); n++ {
+		switch s[n] {
+		case '\\':
+			n++
+		case quote:
+			return n
+		}
+	}
+	return -1
+}
+
+// unescape replaces the escape sequences supported in double-quoted values.
+//
+// Other backslashes are kept.
+func unescape(s string) string {
+	var b strings.Builder
+	for n := 0; n < len(s); n++ {
+		if s[n] != '\\' || n == len(s)-1 {
+			b.WriteByte(s[n])
+			continue
+		}
+		n++
+		switch s[n] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\', '$':
+			b.WriteByte(s[n])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[n])
+		}
+	}
+	return b.String()
+}
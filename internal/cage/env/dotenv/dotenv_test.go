@@ -0,0 +1,78 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package dotenv_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/env/dotenv"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("should parse values", func(t *testing.T) {
+		content := strings.Join([]string{
+			"# comment",
+			"",
+			"PLAIN=value",
+			"  SPACED = value with spaces  ",
+			"EMPTY=",
+			"export EXPORTED=1",
+			"COMMENTED=value # comment",
+			"HASH=a#b",
+			"SINGLE='literal \\n $HOME # not a comment'",
+			`DOUBLE="tab\tnewline\nquote\"backslash\\dollar\$other\x" # comment`,
+			"MULTI=\"line1",
+			"line2\"",
+			"MULTI_SINGLE='a",
+			"",
+			"b'",
+			"EQUALS=a=b",
+			"LAST=crlf\r",
+		}, "\n")
+
+		pairs, err := dotenv.Parse(strings.NewReader(content))
+		require.NoError(t, err)
+		require.Exactly(t, []string{
+			"PLAIN=value",
+			"SPACED=value with spaces",
+			"EMPTY=",
+			"EXPORTED=1",
+			"COMMENTED=value",
+			"HASH=a#b",
+			"SINGLE=literal \\n $HOME # not a comment",
+			"DOUBLE=tab\tnewline\nquote\"backslash\\dollar$other\\x",
+			"MULTI=line1\nline2",
+			"MULTI_SINGLE=a\n\nb",
+			"EQUALS=a=b",
+			"LAST=crlf",
+		}, pairs)
+	})
+
+	t.Run("should keep duplicate names in order", func(t *testing.T) {
+		pairs, err := dotenv.Parse(strings.NewReader("A=1\nA=2\n"))
+		require.NoError(t, err)
+		require.Exactly(t, []string{"A=1", "A=2"}, pairs)
+	})
+
+	t.Run("should report invalid lines", func(t *testing.T) {
+		for content, msg := range map[string]string{
+			"A=1\nNOVALUE":          "line 2: expected NAME=VALUE",
+			"1A=1":                  "line 1: invalid variable name [1A]",
+			"A=\"open\nstill open":  "line 1: unterminated quoted value of [A]",
+			"A='quoted' trailing":   "line 1: unexpected text after the closing quote of [A]",
+			"\n\nA=\"x\ny\" z":      "line 4: unexpected text after the closing quote of [A]",
+			"export A B=1":          "line 1: invalid variable name [A B]",
+			"A=\"escaped end\\\"\n": "line 1: unterminated quoted value of [A]",
+		} {
+			_, err := dotenv.Parse(strings.NewReader(content))
+			require.EqualError(t, err, msg, content)
+		}
+	})
+}
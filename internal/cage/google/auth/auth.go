@@ -8,11 +8,13 @@ package auth
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/pkg/errors"
 	"golang.org/x/oauth2"
@@ -43,6 +45,35 @@ const (
 	RefreshTokenUrlVer4 = "https://www.googleapis.com/oauth2/v4/token" // #nosec
 )
 
+// IDTokenSubject returns the "sub" claim of an ID token, which identifies the user.
+//
+// The token's signature is not verified. The claim is only suitable for purposes like
+// selecting a cache entry, not for authorization decisions.
+func IDTokenSubject(idToken string) (string, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return "", errors.Errorf("ID token has [%d] parts, expected 3", len(parts))
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode ID token payload")
+	}
+
+	claims := struct {
+		Sub string `json:"sub"`
+	}{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return "", errors.Wrap(err, "failed to parse ID token payload")
+	}
+
+	if claims.Sub == "" {
+		return "", errors.New("ID token payload does not contain a subject")
+	}
+
+	return claims.Sub, nil
+}
+
 func ClientFromRefreshToken(ctx context.Context, clientId, clientSecret, refreshToken string) *http.Client {
 	c := oauth2.Config{
 		ClientID:     clientId,
@@ -0,0 +1,30 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package auth_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	google_auth "github.com/codeactual/aws-exec-cmd/internal/cage/google/auth"
+)
+
+func TestIDTokenSubject(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+
+	sub, err := google_auth.IDTokenSubject(header + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1234","aud":"x"}`)) + ".sig")
+	require.NoError(t, err)
+	require.Exactly(t, "1234", sub)
+
+	_, err = google_auth.IDTokenSubject("not-a-jwt")
+	require.EqualError(t, err, "ID token has [1] parts, expected 3")
+
+	_, err = google_auth.IDTokenSubject(header + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"aud":"x"}`)) + ".sig")
+	require.EqualError(t, err, "ID token payload does not contain a subject")
+}
@@ -7,9 +7,13 @@
 package io
 
 import (
+	"bytes"
 	"fmt"
 	std_io "io"
 	"os"
+	"sync"
+
+	"github.com/pkg/errors"
 )
 
 // CloseOrStderr attempts to close a io.Close implementation and outputs to
@@ -21,3 +25,83 @@ func CloseOrStderr(c std_io.Closer, id string) {
 		fmt.Fprintf(os.Stderr, "failed to close io.Closer [%s]: %+v\n", id, err)
 	}
 }
+
+// PrefixWriter writes each line, prefixed, to the underlying writer.
+//
+// Writers which share a mutex write whole lines, so the output of several sources
+// can be interleaved without splitting lines.
+type PrefixWriter struct {
+	w      std_io.Writer
+	mu     *sync.Mutex
+	prefix []byte
+	buf    bytes.Buffer
+}
+
+// NewPrefixWriter returns a PrefixWriter which locks mu, if non-nil, while writing a line to w.
+func NewPrefixWriter(w std_io.Writer, mu *sync.Mutex, prefix string) *PrefixWriter {
+	if mu == nil {
+		mu = &sync.Mutex{}
+	}
+	return &PrefixWriter{w: w, mu: mu, prefix: []byte(prefix)}
+}
+
+// Write buffers p and writes each line it completes.
+//
+// It implements io.Writer.
+func (p *PrefixWriter) Write(b []byte) (int, error) {
+	p.buf.Write(b)
+
+	for {
+		n := bytes.IndexByte(p.buf.Bytes(), '\n')
+		if n < 0 {
+			return len(b), nil
+		}
+		if err := p.writeLine(p.buf.Next(n + 1)); err != nil {
+			return len(b), errors.WithStack(err)
+		}
+	}
+}
+
+// Flush writes the buffered partial line, if any, with a trailing newline.
+func (p *PrefixWriter) Flush() error {
+	if p.buf.Len() == 0 {
+		return nil
+	}
+	line := append(p.buf.Next(p.buf.Len()), '\n')
+	return errors.WithStack(p.writeLine(line))
+}
+
+func (p *PrefixWriter) writeLine(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := p.w.Write(append(append([]byte{}, p.prefix...), line...))
+	return err
+}
+
+var _ std_io.Writer = (*PrefixWriter)(nil)
+
+// LockedWriter writes to the underlying writer while holding a mutex, which other
+// LockedWriter values may share, so that writers which are not goroutine safe can
+// receive concurrent writes.
+type LockedWriter struct {
+	w  std_io.Writer
+	mu *sync.Mutex
+}
+
+// NewLockedWriter returns a LockedWriter which locks mu, if non-nil, while writing to w.
+func NewLockedWriter(w std_io.Writer, mu *sync.Mutex) *LockedWriter {
+	if mu == nil {
+		mu = &sync.Mutex{}
+	}
+	return &LockedWriter{w: w, mu: mu}
+}
+
+// Write writes b to the underlying writer.
+//
+// It implements io.Writer.
+func (l *LockedWriter) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(b)
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package io_test
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	cage_io "github.com/codeactual/aws-exec-cmd/internal/cage/io"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	t.Run("should prefix complete lines", func(t *testing.T) {
+		var out bytes.Buffer
+		w := cage_io.NewPrefixWriter(&out, nil, "[a] ")
+
+		_, err := w.Write([]byte("one\ntw"))
+		require.NoError(t, err)
+		require.Exactly(t, "[a] one\n", out.String())
+
+		_, err = w.Write([]byte("o\nthree"))
+		require.NoError(t, err)
+		require.Exactly(t, "[a] one\n[a] two\n", out.String())
+
+		require.NoError(t, w.Flush())
+		require.Exactly(t, "[a] one\n[a] two\n[a] three\n", out.String())
+
+		require.NoError(t, w.Flush())
+		require.Exactly(t, "[a] one\n[a] two\n[a] three\n", out.String())
+	})
+
+	t.Run("should not split lines from writers with a shared mutex", func(t *testing.T) {
+		var out bytes.Buffer
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+
+		for _, name := range []string{"a", "b"} {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				w := cage_io.NewPrefixWriter(&out, &mu, "["+name+"] ")
+				for n := 0; n < 100; n++ {
+					fmt.Fprintf(w, "line %d\n", n)
+				}
+			}(name)
+		}
+		wg.Wait()
+
+		lines := bytes.Split(bytes.TrimSuffix(out.Bytes(), []byte("\n")), []byte("\n"))
+		require.Len(t, lines, 200)
+		for _, line := range lines {
+			require.Regexp(t, `^\[[ab]\] line \d+$`, string(line))
+		}
+	})
+}
+
+func TestLockedWriter(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, name := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			w := cage_io.NewLockedWriter(&out, &mu)
+			for n := 0; n < 100; n++ {
+				fmt.Fprintf(w, "%s %d\n", name, n)
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	lines := bytes.Split(bytes.TrimSuffix(out.Bytes(), []byte("\n")), []byte("\n"))
+	require.Len(t, lines, 200)
+	for _, line := range lines {
+		require.Regexp(t, `^[ab] \d+$`, string(line))
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"io"
 	"os"
 	std_exec "os/exec"
+	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -24,17 +26,119 @@ import (
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 
+	cage_io "github.com/codeactual/aws-exec-cmd/internal/cage/io"
 	tp_bytes "github.com/codeactual/aws-exec-cmd/internal/third_party/gist.github.com/bytes"
 )
 
-const (
-	SigIntDelay  = 2 * time.Second
-	SigKillDelay = 5 * time.Second
-)
+// StopSignal is one step of the sequence which stops a process group once its context is done.
+type StopSignal struct {
+	// Signal is sent to the group, unless it is 0 and the step only waits.
+	Signal syscall.Signal
+
+	// Wait is how long the group may run after Signal before the next step, if any.
+	Wait time.Duration
+}
+
+// String returns the ParseStopSignals form of the step, e.g. "TERM:10s".
+func (s StopSignal) String() string {
+	if s.Signal == 0 {
+		return s.Wait.String()
+	}
+	if s.Wait == 0 {
+		return SignalName(s.Signal)
+	}
+	return SignalName(s.Signal) + ":" + s.Wait.String()
+}
+
+// DefaultStopSignals interrupts the process group 2 seconds after its context is done, e.g. to let
+// other commands of a failed pipeline finish writing, and kills it 3 seconds later.
+var DefaultStopSignals = []StopSignal{
+	{Wait: 2 * time.Second},
+	{Signal: syscall.SIGINT, Wait: 3 * time.Second},
+	{Signal: syscall.SIGKILL},
+}
+
+// signalNames supports ParseStopSignals and SignalName.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+// SignalName returns the name of the signal without the "SIG" prefix, e.g. "TERM",
+// or its number if it is not supported by ParseStopSignals.
+func SignalName(sig syscall.Signal) string {
+	for name, s := range signalNames {
+		if s == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// ParseStopSignals parses a comma-separated sequence of SIGNAL[:WAIT] or WAIT steps,
+// e.g. "TERM:10s,KILL".
+//
+// Signal names are case-insensitive and may include the "SIG" prefix. Waits use time.ParseDuration
+// format and default to 0.
+func ParseStopSignals(s string) ([]StopSignal, error) {
+	var seq []StopSignal
+
+	for _, step := range strings.Split(s, ",") {
+		step = strings.TrimSpace(step)
+
+		if wait, waitErr := time.ParseDuration(step); waitErr == nil {
+			if wait < 0 {
+				return nil, errors.Errorf("stop signal wait [%s] must not be negative", step)
+			}
+			seq = append(seq, StopSignal{Wait: wait})
+			continue
+		}
+
+		parts := strings.SplitN(step, ":", 2)
+
+		name := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(parts[0])), "SIG")
+		sig, ok := signalNames[name]
+		if !ok {
+			return nil, errors.Errorf("stop signal [%s] must be one of: HUP, INT, QUIT, KILL, USR1, USR2, TERM", parts[0])
+		}
+
+		var wait time.Duration
+		if len(parts) == 2 {
+			var waitErr error
+			if wait, waitErr = time.ParseDuration(strings.TrimSpace(parts[1])); waitErr != nil {
+				return nil, errors.Wrapf(waitErr, "failed to parse wait of stop signal [%s]", step)
+			}
+			if wait < 0 {
+				return nil, errors.Errorf("wait of stop signal [%s] must not be negative", step)
+			}
+		}
+
+		seq = append(seq, StopSignal{Signal: sig, Wait: wait})
+	}
+
+	return seq, nil
+}
+
+// TerminatingSignals are the signals, usually sent by a terminal or supervisor, which
+// commonly end a process and are worth forwarding to a child process.
+var TerminatingSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
 
 type Result struct {
+	// Code is the exit status, or -1 if the process did not start or was terminated by a signal.
 	Code int
 
+	// Signal is the signal which terminated the process, or 0 if it exited normally.
+	Signal syscall.Signal
+
+	// StopSignal is the last StopSignals step sent to the process group before the process
+	// exited, or 0 if the context was not done while it ran.
+	StopSignal syscall.Signal
+
 	// Pid supports concerns like verifying the process exited.
 	Pid int
 
@@ -49,6 +153,15 @@ type Result struct {
 	Err error
 }
 
+// ExitCode returns Code, or 128 plus the signal number if the process was terminated by a signal,
+// following the convention of shells.
+func (r Result) ExitCode() int {
+	if r.Signal != 0 {
+		return 128 + int(r.Signal)
+	}
+	return r.Code
+}
+
 type PipelineResult struct {
 	// Cmd stores more detail about each process executed in the pipeline.
 	Cmd map[*std_exec.Cmd]Result
@@ -70,7 +183,20 @@ type executeOutput struct {
 }
 
 // CommonExecutor provides a general case Executor implementation.
-type CommonExecutor struct{}
+type CommonExecutor struct {
+	// ForwardSignals selects signals which, while Buffered or Standard are running, are caught
+	// instead of handled by their default action and forwarded to the process group of
+	// each started command.
+	ForwardSignals []os.Signal
+
+	// StopSignals is the sequence sent to the process group of each started command once the
+	// context is done. DefaultStopSignals is used if it is empty.
+	StopSignals []StopSignal
+
+	// Started, if not nil, is called after each command starts and its process group
+	// receives the ForwardSignals.
+	Started func(cmd *std_exec.Cmd)
+}
 
 // Command completely delegates to the os/exec method.
 //
@@ -134,6 +260,13 @@ func (c CommonExecutor) execute(input executeInput) (executeOutput, error) {
 
 	output.pipelineResult.Cmd = make(map[*std_exec.Cmd]Result)
 
+	// The standard error of all commands, and the standard output of the last, are copied
+	// concurrently, so serialize the writes in case the writers are not goroutine safe,
+	// e.g. a bytes.Buffer, or are the same writer.
+	var outMu sync.Mutex
+	input.stdout = cage_io.NewLockedWriter(input.stdout, &outMu)
+	input.stderr = cage_io.NewLockedWriter(input.stderr, &outMu)
+
 	// Avoid "res" map data races by processing the result of a pipeline stages, and the related *exec.Cmd
 	// keys, one at a time.
 	//
@@ -184,6 +317,9 @@ func (c CommonExecutor) execute(input executeInput) (executeOutput, error) {
 	g, gCtx := errgroup.WithContext(input.ctx)
 	stageResWg.Add(cmdsLen)
 
+	fwd := c.forwardSignals()
+	defer fwd.stop()
+
 	// second pass: run the pre-connected commands
 	//
 	// - Start all commands "at the same time".
@@ -192,6 +328,7 @@ func (c CommonExecutor) execute(input executeInput) (executeOutput, error) {
 	//   process is started 10s after the second (stdin-receicing) process.
 	for n := cmdsLen - 1; n >= 0; n-- {
 		cmd := input.cmds[n]
+		first := n == 0
 
 		// Update the command-indexed Result returned to the Standard caller.
 		//
@@ -204,6 +341,8 @@ func (c CommonExecutor) execute(input executeInput) (executeOutput, error) {
 			tmp.Code = r.Code
 			tmp.Pid = r.Pid
 			tmp.Pgid = r.Pgid
+			tmp.Signal = r.Signal
+			tmp.StopSignal = r.StopSignal
 			tmp.Err = r.Err
 			output.pipelineResult.Cmd[cmd] = tmp
 			stageResWg.Done()
@@ -234,6 +373,18 @@ func (c CommonExecutor) execute(input executeInput) (executeOutput, error) {
 			// Start process in a group so the context can kill the group as a whole.
 			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
+			// Let the group read the terminal, and receive its signals, instead of being stopped by SIGTTIN.
+			if tty, ok := foregroundTerminal(cmd.Stdin); ok && first {
+				cmd.SysProcAttr.Foreground = true
+				cmd.SysProcAttr.Ctty = 0 // descriptor of cmd.Stdin in the child
+
+				defer func() {
+					if err := restoreForeground(tty); err != nil {
+						fmt.Fprintf(os.Stderr, "failed to restore terminal foreground: %+v\n", errors.WithStack(err))
+					}
+				}()
+			}
+
 			// for all error cases that happen before Wait
 			r.Code = -1
 			r.Pid = -1
@@ -264,6 +415,12 @@ func (c CommonExecutor) execute(input executeInput) (executeOutput, error) {
 				return errors.Errorf("got process group ID 0: %s", CmdToString(input.cmds...))
 			}
 
+			fwd.add(r.Pgid)
+
+			if c.Started != nil {
+				c.Started(cmd)
+			}
+
 			// Work around issue (in 1.10.1) where commands which use cmd.Wait/cmd.Process.Wait
 			// cannot be cancelled as long as standard out/error is being collected.
 			//
@@ -282,29 +439,12 @@ func (c CommonExecutor) execute(input executeInput) (executeOutput, error) {
 			//
 			//     https://github.com/smola/ci-tricks/commit/a0e4714fd033df1f6a3469ce469085af29e06b7f
 			//     https://go-review.googlesource.com/c/go/+/42271/3/misc/android/go_android_exec.go#36
-			go func(ctx context.Context, pgid int) {
-				<-ctx.Done()
-
-				go func() {
-					time.Sleep(SigIntDelay)
-					if err := syscall.Kill(pgid, syscall.SIGINT); err != nil {
-						if err.Error() != "no such process" {
-							fmt.Fprintf(os.Stderr, "failed to SIGINT process group %d: %+v\n", pgid, errors.WithStack(err))
-						}
-					}
-				}()
-				go func() {
-					time.Sleep(SigKillDelay)
-					if err := syscall.Kill(pgid, syscall.SIGKILL); err != nil {
-						if err.Error() != "no such process" {
-							fmt.Fprintf(os.Stderr, "failed to SIGKILL process group %d: %+v\n", pgid, errors.WithStack(err))
-						}
-					}
-				}()
-			}(gCtx, -r.Pgid) // syscall.Kill requires a negative value to denote a process group
+			stopper := c.stopGroupOnDone(gCtx, r.Pgid)
 
 			waitErr := cmd.Wait()
 
+			r.StopSignal = stopper.exited()
+
 			closePipe()
 
 			if waitErr == nil {
@@ -314,14 +454,7 @@ func (c CommonExecutor) execute(input executeInput) (executeOutput, error) {
 			}
 
 			r.Err = waitErr
-			r.Code = 1
-
-			// Try to get a more specific exit code (e.g. on Linux where its supported).
-			if exitErr, ok := waitErr.(*std_exec.ExitError); ok {
-				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-					r.Code = status.ExitStatus()
-				}
-			}
+			r.Code, r.Signal = exitStatus(waitErr)
 
 			updatePipelineRes(r)
 
@@ -335,11 +468,252 @@ func (c CommonExecutor) execute(input executeInput) (executeOutput, error) {
 	return output, errors.WithStack(groupWaitErr)
 }
 
-// Pty runs the command in a pseudo-terminal and returns an error only if the command fails to start.
+// Pty runs the command in a pseudo-terminal connected to the standard in/out of the current process.
+//
+// The Result is stored in the same form as Standard, and the context and ForwardSignals
+// are handled in the same way.
 //
 // It implements an Executor behavior.
-func (c CommonExecutor) Pty(cmd *std_exec.Cmd) error {
-	return tp_exec.Pty(cmd)
+func (c CommonExecutor) Pty(ctx context.Context, cmd *std_exec.Cmd) (res PipelineResult, err error) {
+	if ctx == nil {
+		return PipelineResult{}, errors.New("non-nil context is required")
+	}
+	if cmd == nil {
+		return PipelineResult{}, errors.New("nil command")
+	}
+
+	r := Result{Code: -1, Pid: -1, Pgid: -1}
+	res.Cmd = map[*std_exec.Cmd]Result{cmd: r}
+
+	fwd := c.forwardSignals()
+	defer fwd.stop()
+
+	stopCtx, cancelStop := context.WithCancel(ctx)
+	defer cancelStop()
+
+	var stopper *groupStopper
+
+	started := func() {
+		r.Pid = cmd.Process.Pid
+
+		// The pseudo-terminal command leads its own session and process group.
+		r.Pgid = r.Pid
+
+		fwd.add(r.Pgid)
+		stopper = c.stopGroupOnDone(stopCtx, r.Pgid)
+
+		if c.Started != nil {
+			c.Started(cmd)
+		}
+	}
+
+	ptyErr := tp_exec.Pty(cmd, started)
+	if ptyErr != nil && cmd.Process == nil {
+		r.Err = ptyErr
+		res.Cmd[cmd] = r
+		return res, errors.Wrapf(ptyErr, "failed to start command: %s", CmdToString(cmd))
+	}
+
+	if ptyErr != nil { // started but the terminal could not be prepared
+		_ = syscall.Kill(-r.Pgid, syscall.SIGKILL)
+	}
+
+	waitErr := cmd.Wait()
+
+	if stopper != nil {
+		r.StopSignal = stopper.exited()
+	}
+
+	if ptyErr != nil {
+		r.Err = ptyErr
+		r.Code, r.Signal = exitStatus(waitErr)
+		res.Cmd[cmd] = r
+		return res, errors.Wrapf(ptyErr, "failed to run command in pseudo-terminal: %s", CmdToString(cmd))
+	}
+
+	if waitErr == nil {
+		r.Code = 0
+		res.Cmd[cmd] = r
+		return res, nil
+	}
+
+	r.Err = waitErr
+	r.Code, r.Signal = exitStatus(waitErr)
+	res.Cmd[cmd] = r
+
+	return res, errors.Wrapf(waitErr, "command failed: %s", CmdToString(cmd))
+}
+
+// exitStatus returns the exit code and terminating signal described by a Wait error.
+//
+// The code is 1 if a more specific one is unavailable (e.g. on platforms without
+// syscall.WaitStatus support), and -1 if the process was terminated by a signal.
+func exitStatus(waitErr error) (code int, sig syscall.Signal) {
+	if waitErr == nil {
+		return 0, 0
+	}
+
+	code = 1
+
+	if exitErr, ok := waitErr.(*std_exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			code = status.ExitStatus()
+			if status.Signaled() {
+				sig = status.Signal()
+			}
+		}
+	}
+
+	return code, sig
+}
+
+// groupStopper sends the StopSignals sequence to a process group.
+type groupStopper struct {
+	mu     sync.Mutex
+	last   syscall.Signal
+	done   chan struct{}
+	closed bool
+}
+
+// stopGroupOnDone sends the StopSignals sequence to the process group, whose value is NOT
+// pre-negated, once the context is done.
+//
+// The sequence is not started if exited is called first. Once started, it continues after
+// exited is called, until the group no longer exists, so processes left behind by the command
+// are also stopped.
+func (c CommonExecutor) stopGroupOnDone(ctx context.Context, pgid int) *groupStopper {
+	s := &groupStopper{done: make(chan struct{})}
+
+	seq := c.StopSignals
+	if len(seq) == 0 {
+		seq = DefaultStopSignals
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.done:
+			return
+		}
+
+		// Prefer exited if both are ready, e.g. if the context is cancelled after a normal exit.
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		for n, step := range seq {
+			s.mu.Lock()
+			// syscall.Kill requires a negative value to denote a process group.
+			// Signal 0 only checks that the group still exists.
+			err := syscall.Kill(-pgid, step.Signal)
+			if err == nil && step.Signal != 0 && !s.closed {
+				s.last = step.Signal
+			}
+			s.mu.Unlock()
+
+			if err != nil {
+				if err != syscall.ESRCH {
+					fmt.Fprintf(os.Stderr, "failed to send %s to process group %d: %+v\n", SignalName(step.Signal), pgid, errors.WithStack(err))
+				}
+				return
+			}
+
+			if n < len(seq)-1 {
+				time.Sleep(step.Wait)
+			}
+		}
+	}()
+
+	return s
+}
+
+// exited records that the process ended and returns the last signal sent to its group, if any.
+func (s *groupStopper) exited() syscall.Signal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	return s.last
+}
+
+// signalForwarder forwards caught signals to the process groups added to it.
+type signalForwarder struct {
+	mu    sync.Mutex
+	pgids []int
+	sigCh chan os.Signal
+	done  chan struct{}
+}
+
+// forwardSignals starts forwarding the ForwardSignals, if any, until stop is called.
+func (c CommonExecutor) forwardSignals() *signalForwarder {
+	f := &signalForwarder{}
+
+	if len(c.ForwardSignals) == 0 {
+		return f
+	}
+
+	f.sigCh = make(chan os.Signal, 1)
+	f.done = make(chan struct{})
+	signal.Notify(f.sigCh, c.ForwardSignals...)
+
+	go func() {
+		for {
+			select {
+			case sig := <-f.sigCh:
+				f.mu.Lock()
+				for _, pgid := range f.pgids {
+					// syscall.Kill requires a negative value to denote a process group
+					if err := syscall.Kill(-pgid, sig.(syscall.Signal)); err != nil && err != syscall.ESRCH {
+						fmt.Fprintf(os.Stderr, "failed to forward %s to process group %d: %+v\n", sig, pgid, errors.WithStack(err))
+					}
+				}
+				f.mu.Unlock()
+			case <-f.done:
+				return
+			}
+		}
+	}()
+
+	return f
+}
+
+// add selects a process group, whose value is NOT pre-negated, to receive forwarded signals.
+func (f *signalForwarder) add(pgid int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pgids = append(f.pgids, pgid)
+}
+
+// stop ends signal forwarding.
+func (f *signalForwarder) stop() {
+	if f.sigCh == nil {
+		return
+	}
+	signal.Stop(f.sigCh)
+	close(f.done)
+}
+
+// Replace replaces the current process with the command, which is found in PATH like
+// os/exec.Command if the name does not contain a path separator.
+//
+// It only returns if the replacement fails.
+func Replace(env []string, name string, arg ...string) error {
+	path, lookErr := std_exec.LookPath(name)
+	if lookErr != nil {
+		return errors.WithStack(lookErr)
+	}
+
+	argv := append([]string{name}, arg...)
+
+	if execErr := syscall.Exec(path, argv, env); execErr != nil {
+		return errors.Wrapf(execErr, "failed to replace process with command: %s", path)
+	}
+
+	return nil
 }
 
 // CmdToString stringifies an os/exec.Cmd.
@@ -8,10 +8,14 @@ package exec_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"os/signal"
 	"regexp"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -94,6 +98,14 @@ func requirePipelineStages(t *testing.T, cmds []*exec.Cmd, res cage_exec.Pipelin
 	}
 }
 
+// stopSignalsDelay returns how long a process group may run after its context is done.
+func stopSignalsDelay(seq []cage_exec.StopSignal) (d time.Duration) {
+	for _, step := range seq {
+		d += step.Wait
+	}
+	return d + 100*time.Millisecond
+}
+
 func requireProcessKilled(t *testing.T, res cage_exec.PipelineResult, actualErr error, grandChildPid string, cmds ...*exec.Cmd) {
 	var wg sync.WaitGroup
 	wg.Add(len(cmds))
@@ -103,7 +115,7 @@ func requireProcessKilled(t *testing.T, res cage_exec.PipelineResult, actualErr
 			require.Exactly(t, cancelExitCode, res.Cmd[cmd].Code)
 			require.Contains(t, actualErr.Error(), sigKillMsg)
 
-			time.Sleep(cage_exec.SigKillDelay)
+			time.Sleep(stopSignalsDelay(cage_exec.DefaultStopSignals))
 
 			_, err := cage_os.FindProcess(res.Cmd[cmd].Pid)
 			require.Error(t, err)
@@ -1109,6 +1121,216 @@ func TestBufferedThreeCommands(t *testing.T) {
 	})
 }
 
+func TestForwardSignals(t *testing.T) {
+	t.Run("should forward signal to process group", func(t *testing.T) {
+		// Keep the default action from terminating the test process if the signal arrives
+		// before the executor starts catching it.
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM)
+		defer signal.Stop(sigCh)
+
+		ctx := context.Background()
+		cmd := testecho.NewCmd(ctx, testecho.Input{Sleep: 3})
+
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+		}()
+
+		start := time.Now()
+		executor := cage_exec.CommonExecutor{ForwardSignals: cage_exec.TerminatingSignals}
+		_, _, res, err := executor.Buffered(ctx, cmd)
+
+		require.Error(t, err)
+		require.True(t, time.Since(start) < 3*time.Second)
+		require.Exactly(t, -1, res.Cmd[cmd].Code)
+		require.Exactly(t, syscall.SIGTERM, res.Cmd[cmd].Signal)
+		require.Exactly(t, 128+int(syscall.SIGTERM), res.Cmd[cmd].ExitCode())
+	})
+
+	t.Run("should report exit code if not signaled", func(t *testing.T) {
+		ctx := context.Background()
+		cmd := testecho.NewCmd(ctx, testecho.Input{Code: 3})
+
+		executor := cage_exec.CommonExecutor{ForwardSignals: cage_exec.TerminatingSignals}
+		_, _, res, err := executor.Buffered(ctx, cmd)
+
+		require.Error(t, err)
+		require.Exactly(t, syscall.Signal(0), res.Cmd[cmd].Signal)
+		require.Exactly(t, 3, res.Cmd[cmd].ExitCode())
+	})
+}
+
+func TestStarted(t *testing.T) {
+	t.Run("should be called after each command starts", func(t *testing.T) {
+		ctx := context.Background()
+		cmds := []*exec.Cmd{testecho.NewCmd(ctx), testecho.NewCmd(ctx, testecho.Input{Stdin: true})}
+
+		var mu sync.Mutex
+		pids := map[int]bool{}
+
+		executor := cage_exec.CommonExecutor{Started: func(cmd *exec.Cmd) {
+			mu.Lock()
+			defer mu.Unlock()
+			pids[cmd.Process.Pid] = true
+		}}
+		_, _, res, err := executor.Buffered(ctx, cmds...)
+
+		require.NoError(t, err)
+		require.Len(t, pids, 2)
+		for _, cmd := range cmds {
+			require.True(t, pids[res.Cmd[cmd].Pid])
+		}
+	})
+
+	t.Run("should not be called if the command does not start", func(t *testing.T) {
+		ctx := context.Background()
+		cmd := exec.CommandContext(ctx, badPath)
+
+		var called bool
+		executor := cage_exec.CommonExecutor{Started: func(*exec.Cmd) { called = true }}
+		_, err := executor.Pty(ctx, cmd)
+
+		require.Error(t, err)
+		require.False(t, called)
+	})
+}
+
+func TestPty(t *testing.T) {
+	t.Run("should handle success", func(t *testing.T) {
+		ctx := context.Background()
+		cmd := testecho.NewCmd(ctx, testecho.Input{})
+
+		res, err := cage_exec.CommonExecutor{}.Pty(ctx, cmd)
+
+		require.NoError(t, err)
+		require.Exactly(t, 0, res.Cmd[cmd].Code)
+		requireNonZeroPidPgid(t, res)
+	})
+
+	t.Run("should handle Start failure", func(t *testing.T) {
+		ctx := context.Background()
+		cmd := exec.CommandContext(ctx, badPath)
+
+		res, err := cage_exec.CommonExecutor{}.Pty(ctx, cmd)
+
+		require.Error(t, err)
+		require.Exactly(t, startErrExitCode, res.Cmd[cmd].Code)
+		require.Exactly(t, startErrPid, res.Cmd[cmd].Pid)
+		require.Exactly(t, startErrPgid, res.Cmd[cmd].Pgid)
+	})
+
+	t.Run("should handle Wait failure", func(t *testing.T) {
+		ctx := context.Background()
+		cmd := testecho.NewCmd(ctx, testecho.Input{Code: 3})
+
+		res, err := cage_exec.CommonExecutor{}.Pty(ctx, cmd)
+
+		require.Error(t, err)
+		require.Exactly(t, 3, res.Cmd[cmd].Code)
+		require.Exactly(t, 3, res.Cmd[cmd].ExitCode())
+		requireNonZeroPidPgid(t, res)
+	})
+
+	t.Run("should kill process via context timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		cmd := testecho.NewCmd(ctx, testecho.Input{Sleep: 3})
+
+		res, err := cage_exec.CommonExecutor{}.Pty(ctx, cmd)
+
+		requireProcessKilled(t, res, err, "none", cmd)
+		require.Exactly(t, syscall.SIGKILL, res.Cmd[cmd].Signal)
+		require.Exactly(t, 137, res.Cmd[cmd].ExitCode())
+	})
+}
+
+func TestParseStopSignals(t *testing.T) {
+	t.Run("should parse signals and waits", func(t *testing.T) {
+		seq, err := cage_exec.ParseStopSignals("TERM:10s, sigint:500ms,1s,kill")
+		require.NoError(t, err)
+		require.Exactly(t, []cage_exec.StopSignal{
+			{Signal: syscall.SIGTERM, Wait: 10 * time.Second},
+			{Signal: syscall.SIGINT, Wait: 500 * time.Millisecond},
+			{Wait: time.Second},
+			{Signal: syscall.SIGKILL},
+		}, seq)
+	})
+
+	t.Run("should format the parsed form", func(t *testing.T) {
+		var steps []string
+		for _, step := range cage_exec.DefaultStopSignals {
+			steps = append(steps, step.String())
+		}
+		require.Exactly(t, []string{"2s", "INT:3s", "KILL"}, steps)
+	})
+
+	t.Run("should reject invalid steps", func(t *testing.T) {
+		for _, s := range []string{"", "TERM,", "STOP:1s", "TERM:soon", "TERM:-1s", "-1s"} {
+			_, err := cage_exec.ParseStopSignals(s)
+			require.Error(t, err, s)
+		}
+	})
+}
+
+func TestStopSignals(t *testing.T) {
+	t.Run("should stop process group with first signal", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		cmd := exec.Command(testecho.Which(), testecho.NewCmdArgs(testecho.Input{Sleep: 3})...)
+
+		executor := cage_exec.CommonExecutor{StopSignals: []cage_exec.StopSignal{
+			{Signal: syscall.SIGTERM, Wait: 3 * time.Second},
+			{Signal: syscall.SIGKILL},
+		}}
+		_, _, res, err := executor.Buffered(ctx, cmd)
+
+		require.Error(t, err)
+		require.Exactly(t, syscall.SIGTERM, res.Cmd[cmd].Signal)
+		require.Exactly(t, syscall.SIGTERM, res.Cmd[cmd].StopSignal)
+	})
+
+	t.Run("should escalate if process group ignores signal", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		// testecho does not handle SIGUSR1, which the Go runtime ignores.
+		cmd := exec.Command(testecho.Which(), testecho.NewCmdArgs(testecho.Input{Sleep: 3})...)
+
+		start := time.Now()
+		executor := cage_exec.CommonExecutor{StopSignals: []cage_exec.StopSignal{
+			{Signal: syscall.SIGUSR1, Wait: 500 * time.Millisecond},
+			{Signal: syscall.SIGKILL},
+		}}
+		_, _, res, err := executor.Buffered(ctx, cmd)
+
+		require.Error(t, err)
+		require.True(t, time.Since(start) < 3*time.Second)
+		require.Exactly(t, syscall.SIGKILL, res.Cmd[cmd].Signal)
+		require.Exactly(t, syscall.SIGKILL, res.Cmd[cmd].StopSignal)
+		require.Exactly(t, 137, res.Cmd[cmd].ExitCode())
+	})
+
+	t.Run("should not report signal if process exits first", func(t *testing.T) {
+		ctx := context.Background()
+		cmd := exec.Command(testecho.Which(), testecho.NewCmdArgs(testecho.Input{})...)
+
+		_, _, res, err := cage_exec.CommonExecutor{}.Buffered(ctx, cmd)
+
+		require.NoError(t, err)
+		require.Exactly(t, syscall.Signal(0), res.Cmd[cmd].StopSignal)
+	})
+}
+
+func TestReplace(t *testing.T) {
+	t.Run("should return an error if the command is not found", func(t *testing.T) {
+		err := cage_exec.Replace(os.Environ(), "aws-exec-cmd-replace-missing", "arg0")
+		require.Error(t, err)
+		require.True(t, errors.Is(err, exec.ErrNotFound))
+	})
+}
+
 func TestArgToCmd(t *testing.T) {
 	t.Run("should build commands", func(t *testing.T) {
 		args := cage_strings.SliceOfSlice(
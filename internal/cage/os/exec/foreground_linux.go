@@ -0,0 +1,42 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package exec
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// foregroundTerminal returns the standard input if it is the controlling terminal and the
+// current process group is in its foreground.
+//
+// A command which reads it must then also be in the foreground, instead of only in its own
+// process group, or it is stopped by SIGTTIN.
+func foregroundTerminal(stdin io.Reader) (*os.File, bool) {
+	f, ok := stdin.(*os.File)
+	if !ok || f == nil {
+		return nil, false
+	}
+	pgrp, err := unix.IoctlGetInt(int(f.Fd()), unix.TIOCGPGRP)
+	if err != nil || pgrp != syscall.Getpgrp() {
+		return nil, false
+	}
+	return f, true
+}
+
+// restoreForeground returns the terminal's foreground to the current process group.
+func restoreForeground(f *os.File) error {
+	// The current process group is in the background until the call succeeds.
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+
+	return unix.IoctlSetPointerInt(int(f.Fd()), unix.TIOCSPGRP, syscall.Getpgrp())
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package exec_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kr/pty"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	testecho "github.com/codeactual/aws-exec-cmd/internal/cage/cmd/testecho"
+	cage_exec "github.com/codeactual/aws-exec-cmd/internal/cage/os/exec"
+)
+
+// terminalHelperEnv selects TestInheritTerminalHelper in the process started by TestInheritTerminal.
+const terminalHelperEnv = "CAGE_EXEC_TEST_TERMINAL_HELPER"
+
+// TestInheritTerminal runs TestInheritTerminalHelper in a new session whose controlling terminal
+// is a pseudo-terminal, because the test process cannot change its own.
+func TestInheritTerminal(t *testing.T) {
+	ptmx, tty, err := pty.Open()
+	require.NoError(t, err)
+	defer ptmx.Close()
+	defer tty.Close()
+
+	// Canonical mode passes the line once it ends, and then EOF.
+	_, err = ptmx.Write([]byte(stdinContent + "\n\x04"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestInheritTerminalHelper$") // #nosec
+	cmd.Env = append(os.Environ(), terminalHelperEnv+"=1")
+	cmd.Stdin = tty
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+
+	require.NoError(t, cmd.Run(), "stdout: %s\nstderr: %s", stdout.String(), stderr.String())
+	require.Contains(t, stdout.String(), "echo: stdin ["+stdinContent+"\n]\n")
+	require.Contains(t, stdout.String(), "foreground restored: true\n")
+}
+
+// TestInheritTerminalHelper passes its standard input, the controlling terminal, to testecho
+// with StdinInherit and reports the output and whether the terminal's foreground was restored.
+func TestInheritTerminalHelper(t *testing.T) {
+	if os.Getenv(terminalHelperEnv) == "" {
+		return
+	}
+
+	pgrp, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+	require.NoError(t, err)
+	require.Exactly(t, syscall.Getpgrp(), pgrp)
+
+	in, release, err := cage_exec.OpenStdin(cage_exec.StdinInherit, os.Stdin)
+	require.NoError(t, err)
+	require.True(t, in == os.Stdin)
+
+	// Without the foreground, testecho would be stopped by SIGTTIN and the test would time out.
+	var stdout, stderr bytes.Buffer
+	cmd := testecho.NewCmd(context.Background(), testecho.Input{Stdin: true})
+	_, err = cage_exec.CommonExecutor{}.Standard(context.Background(), &stdout, &stderr, in, cmd)
+	require.NoError(t, err)
+	require.NoError(t, release())
+
+	pgrp, err = unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+	require.NoError(t, err)
+
+	fmt.Printf("echo: %s\n", stdout.String())
+	fmt.Printf("foreground restored: %t\n", pgrp == syscall.Getpgrp())
+}
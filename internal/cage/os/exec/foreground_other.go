@@ -0,0 +1,23 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+//go:build !linux
+// +build !linux
+
+package exec
+
+import (
+	"io"
+	"os"
+)
+
+func foregroundTerminal(stdin io.Reader) (*os.File, bool) {
+	return nil, false
+}
+
+func restoreForeground(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package exec
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// StdinInherit selects the standard input of the current process, including a terminal.
+	StdinInherit = "inherit"
+
+	// StdinPipe selects the standard input of the current process only if it is not a terminal,
+	// e.g. a pipe or a redirected file.
+	StdinPipe = "pipe"
+
+	// StdinNull selects no input, i.e. the null device.
+	StdinNull = "null"
+
+	// StdinFilePrefix selects the file whose path follows the prefix.
+	StdinFilePrefix = "file:"
+)
+
+// OpenStdin returns the standard input of a command selected by a mode, e.g. StdinInherit or
+// "file:/path/to/input", and a function which releases it after the command ends.
+//
+// The current process's standard input is passed to select it or test its type.
+// A nil reader selects the null device, as it does in os/exec.
+func OpenStdin(mode string, stdin *os.File) (r io.Reader, release func() error, err error) {
+	release = func() error { return nil }
+
+	switch {
+	case mode == StdinInherit:
+		return stdin, release, nil
+	case mode == StdinPipe:
+		stat, statErr := stdin.Stat()
+		if statErr != nil {
+			return nil, release, errors.Wrap(statErr, "failed to check if stdin is a terminal")
+		}
+		if stat.Mode()&os.ModeCharDevice == 0 {
+			return stdin, release, nil
+		}
+		return nil, release, nil
+	case mode == StdinNull:
+		return nil, release, nil
+	case strings.HasPrefix(mode, StdinFilePrefix):
+		name := strings.TrimPrefix(mode, StdinFilePrefix)
+		if name == "" {
+			return nil, release, errors.Errorf("stdin mode [%s] requires a file path", mode)
+		}
+		f, openErr := os.Open(name) // #nosec
+		if openErr != nil {
+			return nil, release, errors.Wrapf(openErr, "failed to open stdin file [%s]", name)
+		}
+		return f, f.Close, nil
+	}
+
+	return nil, release, errors.Errorf("stdin mode [%s] must be one of: %s, %s, %s, %sPATH", mode, StdinInherit, StdinPipe, StdinNull, StdinFilePrefix)
+}
+
+// IsStdinMode returns true if the mode is supported by OpenStdin.
+func IsStdinMode(mode string) bool {
+	switch mode {
+	case StdinInherit, StdinPipe, StdinNull:
+		return true
+	}
+	return strings.HasPrefix(mode, StdinFilePrefix) && len(mode) > len(StdinFilePrefix)
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package exec_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	testecho "github.com/codeactual/aws-exec-cmd/internal/cage/cmd/testecho"
+	cage_exec "github.com/codeactual/aws-exec-cmd/internal/cage/os/exec"
+)
+
+const stdinContent = "some stdin message"
+
+// echoStdin runs testecho, which echoes its standard input, with the input selected by the mode.
+func echoStdin(t *testing.T, mode string, stdin *os.File) string {
+	in, release, err := cage_exec.OpenStdin(mode, stdin)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, release())
+	}()
+
+	var stdout, stderr bytes.Buffer
+	cmd := testecho.NewCmd(context.Background(), testecho.Input{Stdin: true})
+	_, err = cage_exec.CommonExecutor{}.Standard(context.Background(), &stdout, &stderr, in, cmd)
+	require.NoError(t, err)
+
+	return stdout.String()
+}
+
+// writeStdinFile writes stdinContent to a new file in the directory and returns its path.
+func writeStdinFile(t *testing.T, dir string) string {
+	name := filepath.Join(dir, "stdin")
+	require.NoError(t, ioutil.WriteFile(name, []byte(stdinContent), 0600))
+	return name
+}
+
+// newStdinPipe returns the read end of a pipe which holds stdinContent.
+func newStdinPipe(t *testing.T) *os.File {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+
+	_, err = w.WriteString(stdinContent)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return r
+}
+
+func TestOpenStdin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cage-exec-stdin")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	name := writeStdinFile(t, dir)
+
+	devNull, err := os.Open(os.DevNull)
+	require.NoError(t, err)
+	defer devNull.Close()
+
+	t.Run("inherit should pass a pipe", func(t *testing.T) {
+		pipe := newStdinPipe(t)
+		defer pipe.Close()
+		require.Exactly(t, "stdin ["+stdinContent+"]", echoStdin(t, cage_exec.StdinInherit, pipe))
+	})
+
+	t.Run("inherit should pass a file", func(t *testing.T) {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+		require.Exactly(t, "stdin ["+stdinContent+"]", echoStdin(t, cage_exec.StdinInherit, f))
+	})
+
+	t.Run("inherit should pass a character device", func(t *testing.T) {
+		in, _, err := cage_exec.OpenStdin(cage_exec.StdinInherit, devNull)
+		require.NoError(t, err)
+		require.True(t, in == devNull)
+		require.Exactly(t, "stdin []", echoStdin(t, cage_exec.StdinInherit, devNull))
+	})
+
+	t.Run("pipe should pass a pipe", func(t *testing.T) {
+		pipe := newStdinPipe(t)
+		defer pipe.Close()
+		require.Exactly(t, "stdin ["+stdinContent+"]", echoStdin(t, cage_exec.StdinPipe, pipe))
+	})
+
+	t.Run("pipe should omit a character device", func(t *testing.T) {
+		in, _, err := cage_exec.OpenStdin(cage_exec.StdinPipe, devNull)
+		require.NoError(t, err)
+		require.Nil(t, in)
+		require.Exactly(t, "stdin []", echoStdin(t, cage_exec.StdinPipe, devNull))
+	})
+
+	t.Run("null should omit a pipe", func(t *testing.T) {
+		pipe := newStdinPipe(t)
+		defer pipe.Close()
+		require.Exactly(t, "stdin []", echoStdin(t, cage_exec.StdinNull, pipe))
+	})
+
+	t.Run("file should pass the file instead of stdin", func(t *testing.T) {
+		pipe, w, err := os.Pipe()
+		require.NoError(t, err)
+		defer pipe.Close()
+		defer w.Close()
+		require.Exactly(t, "stdin ["+stdinContent+"]", echoStdin(t, cage_exec.StdinFilePrefix+name, pipe))
+	})
+
+	t.Run("file should return error if missing", func(t *testing.T) {
+		_, _, err := cage_exec.OpenStdin(cage_exec.StdinFilePrefix+filepath.Join(dir, "missing"), devNull)
+		require.Error(t, err)
+	})
+
+	t.Run("should reject unknown modes", func(t *testing.T) {
+		for _, mode := range []string{"", "terminal", cage_exec.StdinFilePrefix} {
+			require.False(t, cage_exec.IsStdinMode(mode), mode)
+			_, _, err := cage_exec.OpenStdin(mode, devNull)
+			require.Error(t, err, mode)
+		}
+		require.True(t, cage_exec.IsStdinMode(cage_exec.StdinFilePrefix+name))
+	})
+}
@@ -0,0 +1,163 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package shell formats environment variables as statements which set or unset them
+// in a shell, or as data files which other tools load.
+package shell
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// FormatSh selects `export`/`unset` statements for sh, bash, zsh, etc.
+	FormatSh = "sh"
+
+	// FormatFish selects `set -gx`/`set -e` statements.
+	FormatFish = "fish"
+
+	// FormatPowerShell selects `$Env:` assignments and `Remove-Item Env:` statements.
+	FormatPowerShell = "powershell"
+
+	// FormatDotenv selects KEY=value lines, with empty values to unset.
+	FormatDotenv = "dotenv"
+
+	// FormatJSON selects an object of names to values, with null values to unset.
+	FormatJSON = "json"
+)
+
+// Formats holds all supported formats.
+var Formats = []string{FormatSh, FormatFish, FormatPowerShell, FormatDotenv, FormatJSON}
+
+// dotenvBare matches values which do not require quotes in dotenv files.
+var dotenvBare = regexp.MustCompile(`^[A-Za-z0-9_./:+=@,-]*$`)
+
+// IsFormat returns true if the format is supported.
+func IsFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// Export writes statements which set the "KEY=value" pairs.
+func Export(w io.Writer, format string, env []string) error {
+	names := make([]string, len(env))
+	values := make([]string, len(env))
+	for n, pair := range env {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return errors.Errorf("invalid environment variable [%s]", pair)
+		}
+		names[n], values[n] = parts[0], parts[1]
+	}
+
+	if format == FormatJSON {
+		obj := make(map[string]string)
+		for n := range names {
+			obj[names[n]] = values[n]
+		}
+		return writeJSON(w, obj)
+	}
+
+	for n := range names {
+		var line string
+
+		switch format {
+		case FormatSh:
+			line = fmt.Sprintf("export %s=%s", names[n], quoteSh(values[n]))
+		case FormatFish:
+			line = fmt.Sprintf("set -gx %s %s", names[n], quoteFish(values[n]))
+		case FormatPowerShell:
+			line = fmt.Sprintf("$Env:%s = %s", names[n], quotePowerShell(values[n]))
+		case FormatDotenv:
+			line = fmt.Sprintf("%s=%s", names[n], quoteDotenv(values[n]))
+		default:
+			return errors.Errorf("unsupported format [%s]", format)
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// Unset writes statements which unset the named variables.
+func Unset(w io.Writer, format string, names []string) error {
+	if format == FormatJSON {
+		obj := make(map[string]*string)
+		for _, name := range names {
+			obj[name] = nil
+		}
+		return writeJSON(w, obj)
+	}
+
+	for _, name := range names {
+		var line string
+
+		switch format {
+		case FormatSh:
+			line = "unset " + name
+		case FormatFish:
+			line = "set -e " + name
+		case FormatPowerShell:
+			line = "Remove-Item Env:" + name + " -ErrorAction SilentlyContinue"
+		case FormatDotenv:
+			line = name + "="
+		default:
+			return errors.Errorf("unsupported format [%s]", format)
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+func writeJSON(w io.Writer, obj interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.WithStack(enc.Encode(obj))
+}
+
+// quoteSh single-quotes the value for POSIX shells, in which single quotes cannot be escaped
+// inside the quoted string.
+func quoteSh(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// quoteFish single-quotes the value, in which fish supports only \' and \\ escapes.
+func quoteFish(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return "'" + strings.Replace(s, "'", `\'`, -1) + "'"
+}
+
+// quotePowerShell single-quotes the value, in which PowerShell escapes quotes by doubling them.
+func quotePowerShell(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// quoteDotenv leaves common values bare, because some loaders (e.g. `docker --env-file`)
+// do not remove quotes, and double-quotes the rest with backslash escapes.
+func quoteDotenv(s string) string {
+	if dotenvBare.MatchString(s) {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package shell_test
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/os/shell"
+)
+
+func TestExport(t *testing.T) {
+	env := []string{"A=plain", "B=it's a \"$x\"\\"}
+
+	cases := map[string]string{
+		shell.FormatSh:         "export A='plain'\nexport B='it'\\''s a \"$x\"\\'\n",
+		shell.FormatFish:       "set -gx A 'plain'\nset -gx B 'it\\'s a \"$x\"\\\\'\n",
+		shell.FormatPowerShell: "$Env:A = 'plain'\n$Env:B = 'it''s a \"$x\"\\'\n",
+		shell.FormatDotenv:     "A=plain\nB=\"it's a \\\"\\$x\\\"\\\\\"\n",
+		shell.FormatJSON:       "{\n  \"A\": \"plain\",\n  \"B\": \"it's a \\\"$x\\\"\\\\\"\n}\n",
+	}
+
+	for format, expected := range cases {
+		var buf bytes.Buffer
+		require.NoError(t, shell.Export(&buf, format, env), format)
+		require.Exactly(t, expected, buf.String(), format)
+	}
+
+	require.Error(t, shell.Export(&bytes.Buffer{}, "other", env))
+	require.Error(t, shell.Export(&bytes.Buffer{}, shell.FormatSh, []string{"novalue"}))
+}
+
+func TestExportSh(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	value := "it's a \"$x\"\\ `y`"
+
+	var buf bytes.Buffer
+	require.NoError(t, shell.Export(&buf, shell.FormatSh, []string{"B=" + value}))
+
+	out, err := exec.Command("sh", "-c", buf.String()+`printf %s "$B"`).Output() // #nosec
+	require.NoError(t, err)
+	require.Exactly(t, value, string(out))
+}
+
+func TestUnset(t *testing.T) {
+	names := []string{"A", "B"}
+
+	cases := map[string]string{
+		shell.FormatSh:         "unset A\nunset B\n",
+		shell.FormatFish:       "set -e A\nset -e B\n",
+		shell.FormatPowerShell: "Remove-Item Env:A -ErrorAction SilentlyContinue\nRemove-Item Env:B -ErrorAction SilentlyContinue\n",
+		shell.FormatDotenv:     "A=\nB=\n",
+		shell.FormatJSON:       "{\n  \"A\": null,\n  \"B\": null\n}\n",
+	}
+
+	for format, expected := range cases {
+		var buf bytes.Buffer
+		require.NoError(t, shell.Unset(&buf, format, names), format)
+		require.Exactly(t, expected, buf.String(), format)
+	}
+}
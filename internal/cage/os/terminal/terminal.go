@@ -9,6 +9,7 @@ package terminal
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"sync"
 	"syscall"
@@ -35,7 +36,17 @@ func Unlock() {
 	interaction.Unlock()
 }
 
-type DefaultProvider struct{}
+type DefaultProvider struct {
+	// Out receives the prompt messages. If nil, os.Stdout is used.
+	Out io.Writer
+}
+
+func (d DefaultProvider) out() io.Writer {
+	if d.Out == nil {
+		return os.Stdout
+	}
+	return d.Out
+}
 
 // Promptf displays a formatted message and collects a string response.
 //
@@ -46,7 +57,7 @@ func (d DefaultProvider) Promptf(format string, a ...interface{}) (string, error
 	Lock()
 	defer Unlock()
 
-	fmt.Printf(format+" ", a...)
+	fmt.Fprintf(d.out(), format+" ", a...)
 
 	r := bufio.NewReader(os.Stdin)
 
@@ -67,7 +78,7 @@ func (d DefaultProvider) PromptHiddenf(format string, a ...interface{}) (string,
 	Lock()
 	defer Unlock()
 
-	fmt.Printf(format+" \n", a...)
+	fmt.Fprintf(d.out(), format+" \n", a...)
 
 	buf, err := ssh_terminal.ReadPassword(int(syscall.Stdin))
 	if err != nil {
@@ -9,8 +9,13 @@ package reflect
 import std_reflect "reflect"
 
 // GetFieldTag returns the value of a struct field's tag.
+//
+// The val may also be a pointer to the struct, e.g. to avoid copying one which holds a lock.
 func GetFieldTag(val interface{}, field string, key string) string {
 	t := std_reflect.TypeOf(val)
+	if t.Kind() == std_reflect.Ptr {
+		t = t.Elem()
+	}
 	f, found := t.FieldByName(field)
 	if found {
 		return f.Tag.Get(key)
@@ -24,6 +24,7 @@ import (
 // Changes:
 //
 // - Add stringSlice support from https://github.com/spf13/viper.
+// - Add stringArray support.
 func MergeConfig(fs *pflag.FlagSet, v *std_viper.Viper) (lastErr error) {
 	fs.VisitAll(func(f *pflag.Flag) {
 		if f.Changed {
@@ -63,6 +64,16 @@ func MergeConfig(fs *pflag.FlagSet, v *std_viper.Viper) (lastErr error) {
 				lastErr = f.Value.Set(fmt.Sprintf("%v", viperValue)) // write back in expected format
 			}
 
+		case "stringArray":
+			viperValue := v.GetStringSlice(f.Name)
+
+			// Without a config/environment value, viper returns the flag's own value, e.g. "[]".
+			fromFlag := len(viperValue) == 1 && viperValue[0] == flagValue
+
+			if sv, ok := f.Value.(pflag.SliceValue); ok && len(viperValue) != 0 && !fromFlag {
+				lastErr = sv.Replace(viperValue)
+			}
+
 		case "int64", "int32", "int16", "int8", "int":
 			viperValue := strconv.FormatInt(int64(v.GetInt(f.Name)), 10)
 
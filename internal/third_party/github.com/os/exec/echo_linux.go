@@ -0,0 +1,25 @@
+// Copyright (C) 2019 The CodeActual Go Environment Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package exec
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// disableEcho stops the terminal from echoing its input, e.g. so input copied from a
+// non-terminal source does not also appear in the output.
+func disableEcho(f *os.File) error {
+	termios, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	termios.Lflag &^= unix.ECHO
+	return errors.WithStack(unix.IoctlSetTermios(int(f.Fd()), unix.TCSETS, termios))
+}
@@ -13,9 +13,14 @@ import (
 	"golang.org/x/crypto/ssh/terminal"
 )
 
-// Pty runs the command in a pseudo-terminal and returns an error only if the command fails to start.
+// Pty runs the command in a pseudo-terminal and returns once its output ends.
 //
-// It implements an Executor behavior.
+// The started function, if non-nil, is called after the command starts. The caller
+// is responsible for calling cmd.Wait after Pty returns nil.
+//
+// If standard input is not a terminal, it is copied to the pseudo-terminal without raw mode,
+// size inheritance, or echo (on Linux), and end-of-transmission characters are sent once it
+// ends so the command reads end-of-file.
 //
 // Origin:
 //   https://github.com/kr/pty/blob/fa756f09eeb418bf1cc6268c66ceaad9bb98f598/README.md
@@ -23,7 +28,9 @@ import (
 //
 // Changes:
 //   - Migrate to github.com/pkg/errors
-func Pty(cmd *std_exec.Cmd) error {
+//   - Add the started callback and leave cmd.Wait to the caller
+//   - Support standard input which is not a terminal
+func Pty(cmd *std_exec.Cmd, started func()) error {
 	ptmx, err := pty.Start(cmd)
 	if err != nil {
 		return errors.WithStack(err)
@@ -31,27 +38,49 @@ func Pty(cmd *std_exec.Cmd) error {
 	// Make sure to close the pty at the end.
 	defer func() { _ = ptmx.Close() }() // Best effort.
 
-	// Handle pty size.
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGWINCH)
-	go func() {
-		for range ch {
-			if sizeErr := pty.InheritSize(os.Stdin, ptmx); err != nil {
-				fmt.Fprintf(os.Stderr, "error resizing pty: %s", sizeErr)
+	if started != nil {
+		started()
+	}
+
+	stdinFd := int(os.Stdin.Fd())
+
+	if terminal.IsTerminal(stdinFd) {
+		// Handle pty size.
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGWINCH)
+		defer signal.Stop(ch)
+		go func() {
+			for range ch {
+				if sizeErr := pty.InheritSize(os.Stdin, ptmx); sizeErr != nil {
+					fmt.Fprintf(os.Stderr, "error resizing pty: %s", sizeErr)
+				}
 			}
+		}()
+		ch <- syscall.SIGWINCH // Initial resize.
+
+		// Set stdin in raw mode.
+		oldState, err := terminal.MakeRaw(stdinFd)
+		if err != nil {
+			return errors.WithStack(err)
 		}
-	}()
-	ch <- syscall.SIGWINCH // Initial resize.
+		defer func() { _ = terminal.Restore(stdinFd, oldState) }() // Best effort.
 
-	// Set stdin in raw mode.
-	oldState, err := terminal.MakeRaw(int(os.Stdin.Fd()))
-	if err != nil {
-		return errors.WithStack(err)
+		// Copy stdin to the pty.
+		go func() { _, _ = io.Copy(ptmx, os.Stdin) }()
+	} else {
+		if echoErr := disableEcho(ptmx); echoErr != nil {
+			fmt.Fprintf(os.Stderr, "error disabling pty echo: %s", echoErr)
+		}
+
+		// Copy stdin to the pty and then signal end-of-file. The first character flushes any
+		// partial line, and the line discipline reads the second as end-of-file.
+		go func() {
+			_, _ = io.Copy(ptmx, os.Stdin)
+			_, _ = ptmx.Write([]byte{0x04, 0x04})
+		}()
 	}
-	defer func() { _ = terminal.Restore(int(os.Stdin.Fd()), oldState) }() // Best effort.
 
-	// Copy stdin to the pty and the pty to stdout.
-	go func() { _, _ = io.Copy(ptmx, os.Stdin) }()
+	// Copy the pty to stdout.
 	_, _ = io.Copy(os.Stdout, ptmx)
 
 	return nil
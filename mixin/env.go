@@ -0,0 +1,259 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/v1/resource"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/env/dotenv"
+)
+
+// envNameRe matches portable environment variable names.
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// defaultKeepEnv holds the variables inherited with [--clean-env] by default.
+var defaultKeepEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "LANG", "LC_ALL", "TZ", "TMPDIR"}
+
+// metadataEnvNames holds the variables set by metadataEnv, other than the region.
+//
+// They are not inherited because values from an outer run would describe other credentials.
+var metadataEnvNames = []string{AccountIDEnv, RoleArnEnv, RoleSessionNameEnv, RoleChainEnv, SessionEnv, PromptEnv}
+
+// baseEnv returns the variables which the command inherits from the current process.
+//
+// By default, it excludes cage/aws.ConflictingEnv variables, which could override the provided
+// credentials, except those in [--keep-aws-env]. With [--clean-env], it only includes
+// [--keep-env] and [--keep-aws-env] variables. [--unset-env] variables are always excluded.
+//
+// The [--env-file] and [--env] variables follow, unless [--allow-override] defers them to overrideEnv,
+// so the provided credentials take precedence.
+func (m *Exec) baseEnv() []string {
+	env := os.Environ()
+	if m.CleanEnv {
+		env = onlyEnv(env, append(append([]string{}, m.KeepEnv...), m.KeepAwsEnv...)...)
+	}
+	env = withoutEnv(aws.ScrubEnv(env, m.KeepAwsEnv...), metadataEnvNames...)
+	env = withoutEnv(env, m.UnsetEnv...)
+	if !m.AllowOverride {
+		env = append(env, m.userEnv...)
+	}
+	return env
+}
+
+// overrideEnv returns the command's complete environment with the [--env-file] and [--env]
+// variables appended if [--allow-override] is enabled, so they take precedence over all others.
+func (m *Exec) overrideEnv(env []string) []string {
+	if !m.AllowOverride {
+		return env
+	}
+	return append(append([]string{}, env...), m.userEnv...)
+}
+
+// parseUserEnv returns the [--env-file] variables, in file order, followed by the [--env] variables.
+//
+// Unless [--allow-override] is enabled, cage/aws.ConflictingEnv variables are omitted
+// with a warning because they could select other credentials.
+func (m *Exec) parseUserEnv() ([]string, error) {
+	var env []string
+
+	for _, name := range m.EnvFile {
+		f, openErr := os.Open(name) // #nosec
+		if openErr != nil {
+			return nil, errors.Wrapf(openErr, "failed to open --env-file [%s]", name)
+		}
+		pairs, parseErr := dotenv.Parse(f)
+		_ = f.Close()
+		if parseErr != nil {
+			return nil, errors.Wrapf(parseErr, "failed to parse --env-file [%s]", name)
+		}
+		env = append(env, pairs...)
+	}
+
+	for _, pair := range m.Env {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || !envNameRe.MatchString(parts[0]) {
+			return nil, errors.Errorf("--env [%s] must use the format NAME=VALUE", pair)
+		}
+		env = append(env, pair)
+	}
+
+	if m.AllowOverride {
+		return env, nil
+	}
+
+	conflicting := make(map[string]bool, len(aws.ConflictingEnv))
+	for _, name := range aws.ConflictingEnv {
+		conflicting[name] = true
+	}
+
+	var omitted []string
+	for _, pair := range env {
+		if name := strings.SplitN(pair, "=", 2)[0]; conflicting[name] {
+			omitted = append(omitted, name)
+			delete(conflicting, name) // report each name once
+		}
+	}
+	if len(omitted) > 0 {
+		fmt.Fprintf(m.Err(), "ignoring --env-file/--env variables which could override the credentials (see --allow-override): %s\n", strings.Join(omitted, ", "))
+	}
+
+	return withoutEnv(env, aws.ConflictingEnv...), nil
+}
+
+// onlyEnv returns a copy of the "KEY=value" pairs with only the named variables.
+func onlyEnv(env []string, names ...string) []string {
+	keep := make(map[string]bool, len(names))
+	for _, n := range names {
+		keep[n] = true
+	}
+
+	kept := []string{}
+	for _, pair := range env {
+		if keep[strings.SplitN(pair, "=", 2)[0]] {
+			kept = append(kept, pair)
+		}
+	}
+
+	return kept
+}
+
+// withoutEnv returns a copy of the "KEY=value" pairs without the named variables.
+func withoutEnv(env []string, names ...string) []string {
+	omit := make(map[string]bool, len(names))
+	for _, n := range names {
+		omit[n] = true
+	}
+
+	kept := []string{}
+	for _, pair := range env {
+		if !omit[strings.SplitN(pair, "=", 2)[0]] {
+			kept = append(kept, pair)
+		}
+	}
+
+	return kept
+}
+
+// Names of the variables which describe the credentials' principal.
+//
+// They are only provided if the details are known, e.g. not for an identity pool.
+const (
+	AccountIDEnv       = "AWS_EXEC_CMD_ACCOUNT_ID"
+	RoleArnEnv         = "AWS_EXEC_CMD_ROLE_ARN"
+	RoleSessionNameEnv = "AWS_EXEC_CMD_ROLE_SESSION_NAME"
+	RoleChainEnv       = "AWS_EXEC_CMD_ROLE_CHAIN"
+)
+
+// credentialsEnv returns the variables which provide the credentials, and details about them,
+// to the command.
+func (m *Exec) credentialsEnv(a *auth.Mixin, res auth.Result) ([]string, error) {
+	env, envErr := aws.CredentialsEnv(res.Creds)
+	if envErr != nil {
+		return nil, errors.WithStack(envErr)
+	}
+	return m.renameEnv(append(env, metadataEnv(a, res.Arn)...)), nil
+}
+
+// metadataEnv returns the variables which provide the region, if configured, and details
+// about the principal identified by the assumed-role session ARN, if known.
+func metadataEnv(a *auth.Mixin, arn string) []string {
+	var env []string
+
+	if a.Region != "" {
+		env = append(env, aws.RegionEnv+"="+a.Region, aws.DefaultRegionEnv+"="+a.Region)
+	}
+
+	pr := newPrincipal(a, arn)
+
+	for _, v := range [][2]string{
+		{AccountIDEnv, pr.AccountID},
+		{RoleArnEnv, pr.RoleArn},
+		{RoleSessionNameEnv, pr.SessionName},
+		{RoleChainEnv, a.RoleChain},
+	} {
+		if v[1] != "" {
+			env = append(env, v[0]+"="+v[1])
+		}
+	}
+
+	return env
+}
+
+// principal describes the identity of credentials.
+//
+// It is also the [--report] identity.
+type principal struct {
+	// Arn is the principal's ARN, e.g. an assumed-role session ARN, if known.
+	Arn string `json:"arn,omitempty"`
+
+	AccountID   string `json:"account_id,omitempty"`
+	RoleArn     string `json:"role_arn,omitempty"`
+	RoleName    string `json:"role_name,omitempty"`
+	SessionName string `json:"session_name,omitempty"`
+}
+
+// newPrincipal returns the details found in the principal ARN, e.g. an assumed-role session ARN.
+//
+// The role ARN, its name, and the account ID are also read from the last link of the role chain,
+// which unlike the session ARN includes the role's path.
+func newPrincipal(a *auth.Mixin, arn string) principal {
+	pr := principal{Arn: arn, AccountID: resource.AccountID(arn)}
+
+	if assumed, parseErr := resource.ParseAssumedRoleARN(arn); parseErr == nil {
+		pr.RoleArn = assumed.RoleARN()
+		pr.RoleName = assumed.RoleName
+		pr.AccountID = assumed.AccountID
+		pr.SessionName = assumed.SessionName
+	}
+
+	chain := strings.Split(a.RoleChain, ",")
+	if last := strings.TrimSpace(chain[len(chain)-1]); resource.IsARN(last) {
+		pr.RoleArn = last
+		pr.RoleName = path.Base(last)
+		if pr.AccountID == "" {
+			pr.AccountID = resource.AccountID(last)
+		}
+	}
+
+	return pr
+}
+
+// renameEnv applies [--env-name] and [--env-prefix] to the "KEY=value" pairs.
+func (m *Exec) renameEnv(env []string) []string {
+	renamed := make([]string, len(env))
+	for n, pair := range env {
+		parts := strings.SplitN(pair, "=", 2)
+		if name, ok := m.envNames[parts[0]]; ok {
+			renamed[n] = name + "=" + parts[1]
+		} else {
+			renamed[n] = m.EnvPrefix + pair
+		}
+	}
+	return renamed
+}
+
+// parseEnvNames returns the [--env-name] "OLD=NEW" pairs as a map.
+func parseEnvNames(pairs []string) (map[string]string, error) {
+	names := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || !envNameRe.MatchString(parts[0]) || !envNameRe.MatchString(parts[1]) {
+			return nil, errors.Errorf("--env-name [%s] must use the format OLD_NAME=NEW_NAME", pair)
+		}
+		names[parts[0]] = parts[1]
+	}
+	return names, nil
+}
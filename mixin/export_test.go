@@ -0,0 +1,45 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"os/exec"
+	"time"
+
+	cage_exec "github.com/codeactual/aws-exec-cmd/internal/cage/os/exec"
+)
+
+// WriteStagesReport writes a [--report] of a run, which started at the time, with the commands' results.
+func (m *Exec) WriteStagesReport(start time.Time, cmds []*exec.Cmd, res cage_exec.PipelineResult, code int, err error) {
+	r := &runReport{start: start}
+	r.setStages(cmds, res)
+	m.writeReport(r, code, err)
+}
+
+// SupervisedRun describes a [--supervise] run for RestartDecisions.
+type SupervisedRun struct {
+	Code     int
+	Renewed  bool
+	Duration time.Duration
+}
+
+// RestartDecision exports a restartDecision.
+type RestartDecision struct {
+	Restart bool
+	Delay   time.Duration
+	Reason  string
+}
+
+// RestartDecisions returns the [--supervise] decision after each run, in order.
+func (m *Exec) RestartDecisions(runs ...SupervisedRun) (decisions []RestartDecision) {
+	p := m.restartPolicy()
+	for _, r := range runs {
+		d := p.next(r.Code, r.Renewed, r.Duration)
+		decisions = append(decisions, RestartDecision{Restart: d.restart, Delay: d.delay, Reason: d.reason})
+	}
+	return decisions
+}
@@ -0,0 +1,293 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/pkg/errors"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws"
+	cage_resource "github.com/codeactual/aws-exec-cmd/internal/cage/aws/v1/resource"
+	cage_sts "github.com/codeactual/aws-exec-cmd/internal/cage/aws/v1/sts"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+	auth_role "github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth/role"
+	cage_io "github.com/codeactual/aws-exec-cmd/internal/cage/io"
+)
+
+const defaultFanoutConcurrency = 4
+
+// fanoutTarget is one [--fanout] value.
+type fanoutTarget struct {
+	// Name prefixes the target's output lines and identifies it in the summary.
+	Name string
+
+	// Links are appended to the role chain to select the target's credentials.
+	Links string
+}
+
+// fanoutResult describes the run of one target.
+type fanoutResult struct {
+	Code     int
+	Duration time.Duration
+
+	// Err is empty if the command succeeded.
+	Err string
+}
+
+// parseFanoutTarget parses a "NAME=LINKS" or "LINKS" value.
+//
+// If the name is omitted, it is derived from the last link, e.g. "123456789012/audit"
+// for a role ARN.
+func parseFanoutTarget(s string) (fanoutTarget, error) {
+	s = strings.TrimSpace(s)
+
+	var t fanoutTarget
+
+	// Role names may contain '=', so only treat the value as named if the name cannot be part of a link.
+	if n := strings.Index(s, "="); n > 0 && !strings.ContainsAny(s[:n], ":,") {
+		t.Name = strings.TrimSpace(s[:n])
+		s = s[n+1:]
+	}
+
+	var links []string
+	for _, link := range strings.Split(s, ",") {
+		if link = strings.TrimSpace(link); link != "" {
+			links = append(links, link)
+		}
+	}
+	if len(links) == 0 {
+		return fanoutTarget{}, errors.Errorf("--fanout target [%s] must contain at least one role ARN", s)
+	}
+	for _, link := range links {
+		if !cage_resource.IsARN(link) {
+			return fanoutTarget{}, errors.Errorf("--fanout target link [%s] must be a role ARN", link)
+		}
+	}
+	t.Links = strings.Join(links, ",")
+
+	if t.Name == "" {
+		last := links[len(links)-1]
+		t.Name = last
+		if parsed, parseErr := arn.Parse(last); parseErr == nil && strings.HasPrefix(parsed.Resource, "role/") {
+			t.Name = parsed.AccountID + "/" + path.Base(parsed.Resource)
+		}
+	}
+
+	return t, nil
+}
+
+// parseFanoutTargets parses the [--fanout] values and the lines of [--fanout-file], if any.
+func (m *Exec) parseFanoutTargets() ([]fanoutTarget, error) {
+	values := append([]string{}, m.Fanout...)
+
+	if m.FanoutFile != "" {
+		f, openErr := os.Open(m.FanoutFile)
+		if openErr != nil {
+			return nil, errors.Wrapf(openErr, "failed to open --fanout-file [%s]", m.FanoutFile)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			values = append(values, line)
+		}
+		if scanErr := scanner.Err(); scanErr != nil {
+			return nil, errors.Wrapf(scanErr, "failed to read --fanout-file [%s]", m.FanoutFile)
+		}
+	}
+
+	var targets []fanoutTarget
+	names := map[string]bool{}
+
+	for _, v := range values {
+		t, parseErr := parseFanoutTarget(v)
+		if parseErr != nil {
+			return nil, errors.WithStack(parseErr)
+		}
+		if names[t.Name] {
+			return nil, errors.Errorf("--fanout target name [%s] is not unique (use NAME=LINKS)", t.Name)
+		}
+		names[t.Name] = true
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// fanout runs the command once per [--fanout] target, with at most [--fanout-concurrency]
+// running at once, and exits after printing a summary.
+//
+// Credentials are acquired one target at a time, in order, because the auth mixin selects them
+// with its RoleChain field. This also ensures that the first target's MFA prompt, if any, is
+// answered before the others read the session or chain links it cached.
+func (m *Exec) fanout(ctx context.Context, a *auth.Mixin, p auth.Provider, stages [][]string) {
+	if _, ok := p.(*auth_role.Mixin); !ok {
+		fmt.Fprintln(m.Err(), "--fanout is only supported by the role command")
+		os.Exit(1)
+	}
+
+	baseChain := a.RoleChain
+
+	// Share one MFA session among targets whose chains only share the seed alias. It is only
+	// acquired if the seed is long-term, e.g. an IAM user's keys without a session token, because
+	// GetSessionToken rejects temporary credentials. Other seeds, e.g. an instance role, prompt
+	// once per target whose first role is not in the link cache.
+	if a.MfaSerial != "" && baseChain == cage_sts.EnvTempRoleChainAlias {
+		a.MfaSession = true
+	}
+
+	// Keep the MFA prompt, if any, out of the command output.
+	a.PromptOut = m.Err()
+
+	// A background refresh would read RoleChain after it changes to another target's.
+	a.RefreshBackground = false
+
+	base := m.baseEnv()
+	results := make([]fanoutResult, len(m.fanoutTargets))
+
+	var outMu, errMu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, m.FanoutConcurrency)
+
+	for n, t := range m.fanoutTargets {
+		sem <- struct{}{}
+
+		start := time.Now()
+		errOut := cage_io.NewPrefixWriter(m.Err(), &errMu, "["+t.Name+"] ")
+
+		fail := func(msg string, err error) {
+			fmt.Fprintf(errOut, "%s: %s\n", msg, err)
+			_ = errOut.Flush()
+			results[n] = fanoutResult{Code: 1, Duration: time.Since(start), Err: msg}
+			<-sem
+		}
+
+		a.RoleChain = baseChain + "," + t.Links
+		res, credsErr := a.CredentialsResult(p, 0)
+		if credsErr != nil {
+			fail("failed to acquire credentials", credsErr)
+			continue
+		}
+		credsEnv, envErr := m.credentialsEnv(a, res)
+		if envErr != nil {
+			fail("failed to acquire credentials", envErr)
+			continue
+		}
+		env := m.overrideEnv(append(append([]string{}, base...), credsEnv...))
+
+		targetStages := stages
+		if m.Template {
+			var expandErr error
+			if targetStages, expandErr = expandStages(a, res, stages); expandErr != nil {
+				fail("failed to expand command templates", expandErr)
+				continue
+			}
+		}
+
+		wg.Add(1)
+		go func(n int, t fanoutTarget) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			results[n] = m.runFanoutTarget(ctx, t, env, targetStages, &outMu, errOut)
+			results[n].Duration = time.Since(start)
+		}(n, t)
+	}
+
+	wg.Wait()
+
+	m.printFanoutSummary(results)
+
+	for n := len(results) - 1; n >= 0; n-- {
+		if results[n].Code != 0 {
+			m.exit(ctx, a, results[n].Code)
+		}
+	}
+}
+
+// runFanoutTarget runs the command, or pipeline, of one target with prefixed output lines.
+//
+// Targets do not read standard input because they run concurrently.
+func (m *Exec) runFanoutTarget(ctx context.Context, t fanoutTarget, env []string, stages [][]string, outMu *sync.Mutex, errOut *cage_io.PrefixWriter) fanoutResult {
+	if m.Timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(m.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	var cmds []*exec.Cmd
+	for _, stage := range stages {
+		cmds = append(cmds, m.command(stage))
+	}
+
+	out := cage_io.NewPrefixWriter(m.Out(), outMu, "["+t.Name+"] ")
+
+	res, execErr := aws.ExecPipelineWithEnv(ctx, env, out, errOut, nil, m.stopSignals, nil, cmds...)
+
+	_ = out.Flush()
+	_ = errOut.Flush()
+
+	if execErr == nil {
+		return fanoutResult{}
+	}
+
+	if len(cmds) > 1 {
+		printPipelineErrors(errOut, cmds, res)
+	} else {
+		fmt.Fprintln(errOut, execErr)
+		printStopSignal(errOut, res.Cmd[cmds[0]])
+	}
+
+	code := PipelineExitCode(cmds, res)
+	if code == 0 {
+		code = 1
+	}
+
+	for n := len(cmds) - 1; n >= 0; n-- {
+		if r := res.Cmd[cmds[n]]; r.Err != nil {
+			return fanoutResult{Code: code, Err: r.Err.Error()}
+		}
+	}
+
+	return fanoutResult{Code: code, Err: execErr.Error()}
+}
+
+// printFanoutSummary writes a table of the target results to standard error.
+func (m *Exec) printFanoutSummary(results []fanoutResult) {
+	var failed int
+	for _, r := range results {
+		if r.Code != 0 {
+			failed++
+		}
+	}
+
+	w := tabwriter.NewWriter(m.Err(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tEXIT\tDURATION\tERROR")
+	for n, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", m.fanoutTargets[n].Name, r.Code, r.Duration.Round(time.Millisecond), r.Err)
+	}
+	_ = w.Flush()
+
+	fmt.Fprintf(m.Err(), "%d of %d targets failed\n", failed, len(results))
+}
@@ -0,0 +1,268 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pkg/errors"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/server"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/shared"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+)
+
+const (
+	// CredsViaEnv selects credentials provided in environment variables.
+	CredsViaEnv = "env"
+
+	// CredsViaFile selects credentials provided in a temporary shared credentials file.
+	CredsViaFile = "file"
+
+	// credsFileProfile is the profile, in the temporary shared credentials file, selected by AWS_PROFILE.
+	credsFileProfile = "aws-exec-cmd"
+
+	// credsFileRetryDelay is the delay before a failed renewal of the file's credentials is retried.
+	credsFileRetryDelay = 10 * time.Second
+)
+
+// credsFileEnvNames holds the variables which point SDKs to the temporary shared credentials file.
+var credsFileEnvNames = []string{shared.FilenameEnv, "AWS_PROFILE"}
+
+// credsFile writes renewable credentials to a temporary shared credentials file, readable only
+// by the current user, for [--creds-via=file].
+//
+// It returns the command's environment, based on the inherited variables in base, which points
+// SDKs to the file, a function to call once the command has started, and a function which stops
+// renewals and removes the file.
+//
+// The file is removed if the process receives a terminating signal while the initial credentials
+// are acquired. A signal received after credsFile returns, when the command may start at any moment,
+// is held until the started function is called and then forwarded to the command, and the file is
+// removed after it exits. If the command never starts, the held signal terminates the process
+// once the stop function removes the file.
+//
+// Only the initial credentials may be acquired with an MFA prompt. Renewals run while the command
+// owns the terminal, so [--mfa-command] or [--mfa-source] must provide their codes.
+func (m *Exec) credsFile(a *auth.Mixin, p auth.Provider, base []string) (env []string, started func(), stop func(), err error) {
+	written := false
+
+	refresher := server.NewRefresher(
+		func(minRemaining time.Duration) (*credentials.Credentials, error) {
+			if written {
+				return a.RenewCredentials(p, minRemaining)
+			}
+			return a.CredentialsMinRemaining(p, minRemaining)
+		},
+		time.Duration(m.ServeRefreshBeforeSec)*time.Second,
+	)
+
+	dir, dirErr := ioutil.TempDir("", "aws-exec-cmd-")
+	if dirErr != nil {
+		return nil, nil, nil, errors.Wrap(dirErr, "failed to create temp dir for credentials file")
+	}
+
+	filename := filepath.Join(dir, "credentials")
+
+	var removeOnce sync.Once
+	remove := func() {
+		removeOnce.Do(func() {
+			if removeErr := os.RemoveAll(dir); removeErr != nil {
+				fmt.Fprintf(m.Err(), "failed to remove credentials file dir [%s]: %+v\n", dir, removeErr)
+			}
+		})
+	}
+
+	signals := handleTerminatingSignals(remove)
+
+	write := func() (time.Time, error) {
+		v, expiration, getErr := refresher.Get()
+		if getErr != nil {
+			return time.Time{}, errors.WithStack(getErr)
+		}
+		props := shared.CredentialsProperties(v, expiration)
+		if writeErr := shared.WriteProfile(filename, credsFileProfile, props); writeErr != nil {
+			return time.Time{}, errors.WithStack(writeErr)
+		}
+		return expiration, nil
+	}
+
+	// Fail before the command starts if the initial credentials are unavailable.
+	expiration, writeErr := write()
+	if writeErr != nil {
+		signals.release()
+		return nil, nil, nil, errors.WithStack(writeErr)
+	}
+
+	// The goroutine below only renews the credentials.
+	written = true
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		for !expiration.IsZero() {
+			delay := time.Until(expiration) - refresher.Before
+			if delay < time.Second {
+				delay = time.Second
+			}
+
+			select {
+			case <-done:
+				return
+			case <-time.After(delay):
+			}
+
+			next, renewErr := write()
+			if renewErr != nil {
+				fmt.Fprintf(m.Err(), "failed to renew credentials file: %+v\n", renewErr)
+				next = time.Now().Add(refresher.Before + credsFileRetryDelay)
+			}
+			expiration = next
+		}
+	}()
+
+	env = append(
+		withoutEnv(base, credsFileEnvNames...),
+		shared.FilenameEnv+"="+filename,
+		"AWS_PROFILE="+credsFileProfile,
+	)
+
+	stop = func() {
+		close(done)
+		<-stopped
+		signals.release()
+	}
+
+	signals.arm()
+
+	// The executor forwards signals to the command, once it starts, instead of letting them
+	// terminate this process before the command exits.
+	return env, signals.handoff, stop, nil
+}
+
+// signalHandoff handles SIGINT, SIGTERM, and SIGHUP until another handler, e.g. the executor's
+// signal forwarder, takes over.
+//
+// Until arm is called, a signal runs the cleanup function and then terminates the process with
+// the same signal. Afterward the signal is held, instead, because a command may have started
+// which must not be orphaned.
+type signalHandoff struct {
+	cleanup func()
+
+	sigCh chan os.Signal
+	done  chan struct{}
+
+	mu      sync.Mutex
+	armed   bool
+	stopped bool
+	pending syscall.Signal
+}
+
+// handleTerminatingSignals starts handling the signals with the cleanup function.
+func handleTerminatingSignals(cleanup func()) *signalHandoff {
+	h := &signalHandoff{
+		cleanup: cleanup,
+		sigCh:   make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+	}
+
+	signal.Notify(h.sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	go func() {
+		for {
+			select {
+			case received := <-h.sigCh:
+				sig := received.(syscall.Signal)
+
+				h.mu.Lock()
+				if h.stopped {
+					h.mu.Unlock()
+					return
+				}
+				if h.armed {
+					if h.pending == 0 {
+						h.pending = sig
+					}
+					h.mu.Unlock()
+					continue
+				}
+
+				// Keep the lock so that arm and handoff cannot proceed while the process terminates.
+				h.cleanup()
+				signal.Reset(sig)
+				_ = syscall.Kill(os.Getpid(), sig)
+				return
+			case <-h.done:
+				return
+			}
+		}
+	}()
+
+	return h
+}
+
+// arm starts holding signals instead of terminating the process.
+func (h *signalHandoff) arm() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.armed = true
+}
+
+// handoff stops the handling, once another handler receives the signals, and sends the held
+// signal, if any, to this process again so that the other handler receives it, e.g. to forward
+// it to the command which just started.
+//
+// It is idempotent.
+func (h *signalHandoff) handoff() {
+	h.mu.Lock()
+	h.stopLocked()
+	sig := h.pending
+	h.pending = 0
+	h.mu.Unlock()
+
+	if sig != 0 {
+		_ = syscall.Kill(os.Getpid(), sig)
+	}
+}
+
+// release stops the handling and runs the cleanup function. If a signal is still held,
+// i.e. handoff was not called, it then terminates the process.
+func (h *signalHandoff) release() {
+	h.mu.Lock()
+	h.stopLocked()
+	sig := h.pending
+	h.pending = 0
+	h.mu.Unlock()
+
+	h.cleanup()
+
+	if sig != 0 {
+		signal.Reset(sig)
+		_ = syscall.Kill(os.Getpid(), sig)
+	}
+}
+
+// stopLocked stops receiving signals. The caller must hold mu.
+func (h *signalHandoff) stopLocked() {
+	if h.stopped {
+		return
+	}
+	h.stopped = true
+	signal.Stop(h.sigCh)
+	close(h.done)
+}
@@ -9,33 +9,146 @@ package mixin
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
 	"github.com/codeactual/aws-exec-cmd/internal/cage/aws"
 	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+	cage_exec "github.com/codeactual/aws-exec-cmd/internal/cage/os/exec"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/os/shell"
 	cage_reflect "github.com/codeactual/aws-exec-cmd/internal/cage/reflect"
 )
 
 const (
 	defaultTimeout = 0
+
+	// defaultStopSignals keeps the initial grace period of cage/os/exec.DefaultStopSignals, e.g. to let
+	// the other commands of a failed pipeline finish writing.
+	defaultStopSignals = "2s,TERM:5s,KILL"
+
+	defaultServeRefreshBeforeSec = 300
 )
 
 type Exec struct {
 	handler.Session
 
 	Pty     bool `usage:"Run in a pseudo-terminal"`
-	Timeout int  `usage:"Number of seconds to wait for a non-[--pty] command to finish"`
+	Timeout int  `usage:"Number of seconds to wait for the command to finish"`
+
+	Stdin string `usage:"Standard input of the command: inherit (including a terminal), pipe (only if not a terminal), null, or file:PATH"`
+
+	StopSignals string `usage:"Signals sent to the command's process group once it must stop, e.g. after [--timeout] or the failure of another pipeline command, each followed by how long to wait for it to exit (a step without a signal only waits), e.g. 2s,TERM:30s,KILL"`
+
+	PipelineSep string `usage:"Argument which separates the commands of a pipeline, e.g. ::: to run cmd1 ::: cmd2 (pipelines are disabled by default)"`
+
+	Supervise            bool   `usage:"Keep the command running: restart it per [--restart], and with renewed credentials [--serve-refresh-before] seconds before they expire"`
+	Restart              string `usage:"When [--supervise] restarts the command: always or on-failure"`
+	RestartBackoffSec    int    `usage:"Seconds before [--supervise] restarts a failed command, doubled after each consecutive failure"`
+	RestartBackoffMaxSec int    `usage:"Maximum seconds before [--supervise] restarts a failed command, and how long a run must last to reset the delay"`
+	MaxRestarts          int    `usage:"Number of restarts after which [--supervise] exits with the command's exit code, or 0 for no limit"`
+
+	Report string `usage:"Write a JSON report of the run, e.g. its identity, timing, and exit codes, to this file"`
+
+	Replace bool `usage:"Replace this process with the command, instead of running it as a child, e.g. so it receives signals directly"`
+
+	Shell       bool `usage:"Run $SHELL (or /bin/sh) in a pseudo-terminal with the credentials instead of a command"`
+	AllowNested bool `usage:"Run inside a [--shell] session even if its credentials would seed an env-triple role chain"`
+
+	Template bool `usage:"Expand Go templates in the command arguments, e.g. logs-{{.AccountID}}, with fields AccountID, Arn, RoleName, SessionName, Region, and Expiration"`
+
+	Fanout            []string `usage:"Run the command once per target whose role ARN(s) are appended to the role chain, e.g. arn:aws:iam::123456789012:role/audit or NAME=ARN[,ARN...] (repeatable)"`
+	FanoutFile        string   `usage:"File with one [--fanout] target per line (blank lines and # comments are ignored)"`
+	FanoutConcurrency int      `usage:"Maximum number of [--fanout] targets whose commands run at once"`
+
+	CredsVia string `usage:"How the command receives the credentials: env (variables) or file (a temporary shared credentials file which is renewed before expiration)"`
+
+	ServeCredentials      bool `usage:"Serve renewable credentials to the command from a localhost container-credentials endpoint instead of static environment variables"`
+	ServeRefreshBeforeSec int  `usage:"Number of seconds before expiration that [--serve-credentials], [--serve-imds], [--creds-via=file], or [--supervise] renews the credentials"`
+
+	CleanEnv   bool     `usage:"Only inherit the [--keep-env] and [--keep-aws-env] variables from the current environment"`
+	KeepEnv    []string `usage:"Variables inherited with [--clean-env] (replaces the default list)"`
+	KeepAwsEnv []string `usage:"Variables which could override the provided credentials, e.g. AWS_PROFILE, but are inherited instead of removed"`
+	UnsetEnv   []string `usage:"Variables never inherited from the current environment"`
+
+	EnvFile       []string `usage:"File of variables provided to the command, in dotenv format, e.g. NAME=value or NAME=\"quoted value\" (repeatable)"`
+	Env           []string `usage:"Variable provided to the command, e.g. NAME=value, after those of [--env-file] (repeatable)"`
+	AllowOverride bool     `usage:"Let [--env-file] and [--env] variables override the provided AWS credentials and related variables"`
+
+	Cwd string `usage:"Working directory of the command"`
+
+	EnvName   []string `usage:"Rename a variable provided to the command, e.g. AWS_ACCESS_KEY_ID=TF_VAR_access_key (repeatable)"`
+	EnvPrefix string   `usage:"Prefix the names of variables provided to the command, e.g. TF_VAR_, unless renamed by [--env-name]"`
+
+	Print string `usage:"Print the credentials instead of running a command, in a format: sh, fish, powershell, dotenv, or json"`
+
+	WriteProfile string `usage:"Write the credentials into this profile of the shared credentials file, e.g. ~/.aws/credentials, before running the optional command"`
+
+	CredentialProcess bool `usage:"Print the credentials, instead of running a command, as credential_process output for ~/.aws/config"`
+
+	ServeImds        bool   `usage:"Serve renewable credentials to the command from a localhost EC2 instance metadata service emulator (IMDSv2, or IMDSv1 if allowed). Other local users and processes can also reach it"`
+	ImdsRoleName     string `usage:"Instance profile role name reported by [--serve-imds]"`
+	ImdsRequireToken bool   `usage:"Reject [--serve-imds] requests which lack an IMDSv2 session token (use =false to allow IMDSv1)"`
+
+	// userEnv holds the parsed EnvFile and Env pairs.
+	userEnv []string
+
+	// envNames holds the parsed EnvName pairs.
+	envNames map[string]string
+
+	// fanoutTargets holds the parsed Fanout and FanoutFile targets.
+	fanoutTargets []fanoutTarget
+
+	// stopSignals holds the parsed StopSignals sequence.
+	stopSignals []cage_exec.StopSignal
 }
 
 // Implements cage/cli/handler.Mixin
 func (m *Exec) BindCobraFlags(cmd *cobra.Command) []string {
 	cmd.Flags().IntVarP(&m.Timeout, "timeout", "", defaultTimeout, cage_reflect.GetFieldTag(*m, "Timeout", "usage"))
+	cmd.Flags().StringVarP(&m.Stdin, "stdin", "", cage_exec.StdinPipe, cage_reflect.GetFieldTag(*m, "Stdin", "usage"))
+	cmd.Flags().StringVarP(&m.StopSignals, "stop-signals", "", defaultStopSignals, cage_reflect.GetFieldTag(*m, "StopSignals", "usage"))
 	cmd.Flags().BoolVarP(&m.Pty, "pty", "", false, cage_reflect.GetFieldTag(*m, "Pty", "usage"))
+	cmd.Flags().StringVarP(&m.PipelineSep, "pipeline-sep", "", "", cage_reflect.GetFieldTag(*m, "PipelineSep", "usage"))
+	cmd.Flags().BoolVarP(&m.Supervise, "supervise", "", false, cage_reflect.GetFieldTag(*m, "Supervise", "usage"))
+	cmd.Flags().StringVarP(&m.Restart, "restart", "", RestartOnFailure, cage_reflect.GetFieldTag(*m, "Restart", "usage"))
+	cmd.Flags().IntVarP(&m.RestartBackoffSec, "restart-backoff", "", defaultRestartBackoffSec, cage_reflect.GetFieldTag(*m, "RestartBackoffSec", "usage"))
+	cmd.Flags().IntVarP(&m.RestartBackoffMaxSec, "restart-backoff-max", "", defaultRestartBackoffMaxSec, cage_reflect.GetFieldTag(*m, "RestartBackoffMaxSec", "usage"))
+	cmd.Flags().IntVarP(&m.MaxRestarts, "max-restarts", "", 0, cage_reflect.GetFieldTag(*m, "MaxRestarts", "usage"))
+	cmd.Flags().StringVarP(&m.Report, "report", "", "", cage_reflect.GetFieldTag(*m, "Report", "usage"))
+	cmd.Flags().BoolVarP(&m.Replace, "replace", "", false, cage_reflect.GetFieldTag(*m, "Replace", "usage"))
+	cmd.Flags().BoolVarP(&m.Shell, "shell", "", false, cage_reflect.GetFieldTag(*m, "Shell", "usage"))
+	cmd.Flags().BoolVarP(&m.AllowNested, "allow-nested", "", false, cage_reflect.GetFieldTag(*m, "AllowNested", "usage"))
+	cmd.Flags().BoolVarP(&m.Template, "template", "", false, cage_reflect.GetFieldTag(*m, "Template", "usage"))
+	cmd.Flags().StringArrayVarP(&m.Fanout, "fanout", "", []string{}, cage_reflect.GetFieldTag(*m, "Fanout", "usage"))
+	cmd.Flags().StringVarP(&m.FanoutFile, "fanout-file", "", "", cage_reflect.GetFieldTag(*m, "FanoutFile", "usage"))
+	cmd.Flags().IntVarP(&m.FanoutConcurrency, "fanout-concurrency", "", defaultFanoutConcurrency, cage_reflect.GetFieldTag(*m, "FanoutConcurrency", "usage"))
+	cmd.Flags().StringVarP(&m.CredsVia, "creds-via", "", CredsViaEnv, cage_reflect.GetFieldTag(*m, "CredsVia", "usage"))
+	cmd.Flags().BoolVarP(&m.ServeCredentials, "serve-credentials", "", false, cage_reflect.GetFieldTag(*m, "ServeCredentials", "usage"))
+	cmd.Flags().IntVarP(&m.ServeRefreshBeforeSec, "serve-refresh-before", "", defaultServeRefreshBeforeSec, cage_reflect.GetFieldTag(*m, "ServeRefreshBeforeSec", "usage"))
+	cmd.Flags().BoolVarP(&m.CleanEnv, "clean-env", "", false, cage_reflect.GetFieldTag(*m, "CleanEnv", "usage"))
+	cmd.Flags().StringSliceVarP(&m.KeepEnv, "keep-env", "", defaultKeepEnv, cage_reflect.GetFieldTag(*m, "KeepEnv", "usage"))
+	cmd.Flags().StringSliceVarP(&m.KeepAwsEnv, "keep-aws-env", "", []string{}, cage_reflect.GetFieldTag(*m, "KeepAwsEnv", "usage"))
+	cmd.Flags().StringSliceVarP(&m.UnsetEnv, "unset-env", "", []string{}, cage_reflect.GetFieldTag(*m, "UnsetEnv", "usage"))
+	cmd.Flags().StringArrayVarP(&m.EnvFile, "env-file", "", []string{}, cage_reflect.GetFieldTag(*m, "EnvFile", "usage"))
+	cmd.Flags().StringArrayVarP(&m.Env, "env", "", []string{}, cage_reflect.GetFieldTag(*m, "Env", "usage"))
+	cmd.Flags().BoolVarP(&m.AllowOverride, "allow-override", "", false, cage_reflect.GetFieldTag(*m, "AllowOverride", "usage"))
+	cmd.Flags().StringVarP(&m.Cwd, "cwd", "", "", cage_reflect.GetFieldTag(*m, "Cwd", "usage"))
+	cmd.Flags().StringSliceVarP(&m.EnvName, "env-name", "", []string{}, cage_reflect.GetFieldTag(*m, "EnvName", "usage"))
+	cmd.Flags().StringVarP(&m.EnvPrefix, "env-prefix", "", "", cage_reflect.GetFieldTag(*m, "EnvPrefix", "usage"))
+	cmd.Flags().StringVarP(&m.Print, "print", "", "", cage_reflect.GetFieldTag(*m, "Print", "usage"))
+	cmd.Flags().StringVarP(&m.WriteProfile, "write-profile", "", "", cage_reflect.GetFieldTag(*m, "WriteProfile", "usage"))
+	cmd.Flags().BoolVarP(&m.CredentialProcess, "credential-process", "", false, cage_reflect.GetFieldTag(*m, "CredentialProcess", "usage"))
+	cmd.Flags().BoolVarP(&m.ServeImds, "serve-imds", "", false, cage_reflect.GetFieldTag(*m, "ServeImds", "usage"))
+	cmd.Flags().StringVarP(&m.ImdsRoleName, "imds-role-name", "", defaultImdsRoleName, cage_reflect.GetFieldTag(*m, "ImdsRoleName", "usage"))
+	cmd.Flags().BoolVarP(&m.ImdsRequireToken, "imds-require-token", "", true, cage_reflect.GetFieldTag(*m, "ImdsRequireToken", "usage"))
 	return []string{}
 }
 
@@ -45,39 +158,417 @@ func (m *Exec) Name() string {
 }
 
 // Implements cage/cli/handler.Mixin
-func (m *Exec) PreRun(ctx context.Context) error {
+func (m *Exec) PreRun(ctx context.Context, args []string) error {
+	var namesErr error
+	if m.envNames, namesErr = parseEnvNames(m.EnvName); namesErr != nil {
+		return errors.WithStack(namesErr)
+	}
+	if m.EnvPrefix != "" && !envNameRe.MatchString(m.EnvPrefix) {
+		return errors.Errorf("--env-prefix [%s] must only contain letters, digits, and underscores", m.EnvPrefix)
+	}
+
+	var userEnvErr error
+	if m.userEnv, userEnvErr = m.parseUserEnv(); userEnvErr != nil {
+		return errors.WithStack(userEnvErr)
+	}
+
+	if m.Cwd != "" {
+		fi, statErr := os.Stat(m.Cwd)
+		if statErr != nil {
+			return errors.Wrapf(statErr, "--cwd [%s] is invalid", m.Cwd)
+		}
+		if !fi.IsDir() {
+			return errors.Errorf("--cwd [%s] is not a directory", m.Cwd)
+		}
+	}
+
+	var stopErr error
+	if m.stopSignals, stopErr = cage_exec.ParseStopSignals(m.StopSignals); stopErr != nil {
+		return errors.Wrapf(stopErr, "--stop-signals [%s] is invalid", m.StopSignals)
+	}
+
+	if !cage_exec.IsStdinMode(m.Stdin) {
+		return errors.Errorf("--stdin [%s] must be one of: %s, %s, %s, %sPATH", m.Stdin, cage_exec.StdinInherit, cage_exec.StdinPipe, cage_exec.StdinNull, cage_exec.StdinFilePrefix)
+	}
+	if m.Stdin != cage_exec.StdinPipe && (m.Pty || m.Shell || len(m.Fanout) > 0 || m.FanoutFile != "") {
+		return errors.New("--stdin cannot be combined with --pty, --shell, or --fanout, which select their own standard input")
+	}
+
+	stages, stagesErr := SplitPipeline(args, m.PipelineSep)
+	if stagesErr != nil {
+		return errors.WithStack(stagesErr)
+	}
+	if len(stages) > 1 && m.Pty {
+		return errors.New("--pty does not support pipelines")
+	}
+
+	if m.Replace {
+		if len(args) == 0 {
+			return errors.New("--replace requires a command")
+		}
+		if len(stages) > 1 {
+			return errors.New("--replace does not support pipelines")
+		}
+		if m.Timeout > 0 || m.Pty || m.Shell || len(m.Fanout) > 0 || m.FanoutFile != "" || m.ServeCredentials || m.ServeImds || m.CredsVia != CredsViaEnv {
+			return errors.New("--replace cannot be combined with --timeout, --pty, --shell, --fanout, --serve-credentials, --serve-imds, or --creds-via=file")
+		}
+		if m.Stdin != cage_exec.StdinPipe && m.Stdin != cage_exec.StdinInherit {
+			return errors.New("--replace always inherits standard input and cannot be combined with --stdin null or file:PATH")
+		}
+	}
+	if m.Supervise {
+		if len(args) == 0 {
+			return errors.New("--supervise requires a command")
+		}
+		if m.Replace || m.Shell || len(m.Fanout) > 0 || m.FanoutFile != "" || m.Report != "" {
+			return errors.New("--supervise cannot be combined with --replace, --shell, --fanout, or --report")
+		}
+		if m.Restart != RestartAlways && m.Restart != RestartOnFailure {
+			return errors.Errorf("--restart [%s] must be one of: %s, %s", m.Restart, RestartAlways, RestartOnFailure)
+		}
+		if m.RestartBackoffSec < 0 || m.RestartBackoffMaxSec < m.RestartBackoffSec {
+			return errors.New("--restart-backoff must be at least 0 and at most --restart-backoff-max")
+		}
+		if m.MaxRestarts < 0 {
+			return errors.New("--max-restarts must be at least 0")
+		}
+	}
+	if m.Report != "" {
+		if len(args) == 0 && !m.Shell {
+			return errors.New("--report requires a command")
+		}
+		if m.Replace || len(m.Fanout) > 0 || m.FanoutFile != "" {
+			return errors.New("--report cannot be combined with --replace or --fanout")
+		}
+	}
+	if m.Shell {
+		if len(args) > 0 {
+			return errors.New("--shell does not accept a command")
+		}
+		if m.Print != "" || m.CredentialProcess || len(m.Fanout) > 0 || m.FanoutFile != "" {
+			return errors.New("--shell cannot be combined with --print, --credential-process, or --fanout")
+		}
+	}
+	if m.Template {
+		if len(args) == 0 {
+			return errors.New("--template requires a command")
+		}
+		if templateErr := checkArgTemplates(args); templateErr != nil {
+			return errors.WithStack(templateErr)
+		}
+	}
+
+	var fanoutErr error
+	if m.fanoutTargets, fanoutErr = m.parseFanoutTargets(); fanoutErr != nil {
+		return errors.WithStack(fanoutErr)
+	}
+	if len(m.fanoutTargets) > 0 {
+		if len(args) == 0 {
+			return errors.New("--fanout requires a command")
+		}
+		if m.Pty || m.Print != "" || m.CredentialProcess || m.WriteProfile != "" || m.ServeCredentials || m.ServeImds || m.CredsVia != CredsViaEnv {
+			return errors.New("--fanout cannot be combined with --pty, --print, --credential-process, --write-profile, --serve-credentials, --serve-imds, or --creds-via=file")
+		}
+		if m.FanoutConcurrency < 1 {
+			return errors.New("--fanout-concurrency must be at least 1")
+		}
+	}
+
+	switch m.CredsVia {
+	case CredsViaEnv:
+	case CredsViaFile:
+		if m.Print != "" || m.CredentialProcess || m.ServeCredentials || m.ServeImds {
+			return errors.New("--creds-via=file cannot be combined with --print, --credential-process, --serve-credentials, or --serve-imds")
+		}
+	default:
+		return errors.Errorf("--creds-via [%s] must be one of: %s, %s", m.CredsVia, CredsViaEnv, CredsViaFile)
+	}
+	if m.Print != "" {
+		if !shell.IsFormat(m.Print) {
+			return errors.Errorf("--print [%s] must be one of: %s", m.Print, strings.Join(shell.Formats, ", "))
+		}
+		if len(args) > 0 {
+			return errors.New("--print does not accept a command")
+		}
+		if m.ServeCredentials || m.ServeImds {
+			return errors.New("--print cannot be combined with --serve-credentials or --serve-imds")
+		}
+	}
+	if m.CredentialProcess {
+		if m.Print != "" {
+			return errors.New("--credential-process cannot be combined with --print")
+		}
+		if len(args) > 0 {
+			return errors.New("--credential-process does not accept a command")
+		}
+		if m.ServeCredentials || m.ServeImds {
+			return errors.New("--credential-process cannot be combined with --serve-credentials or --serve-imds")
+		}
+	}
+	if strings.ContainsAny(m.WriteProfile, "[]\r\n") {
+		return errors.Errorf("--write-profile [%s] must not contain brackets or line breaks", m.WriteProfile)
+	}
+	if m.ServeImds && (m.ImdsRoleName == "" || strings.Contains(m.ImdsRoleName, "/")) {
+		return errors.Errorf("--imds-role-name [%s] must be non-empty and must not contain '/'", m.ImdsRoleName)
+	}
 	return nil
 }
 
-func (m *Exec) Do(ctx context.Context, creds *credentials.Credentials, args []string) {
-	if len(args) == 0 {
+// Do acquires credentials from the provider and runs the command with them, or prints
+// them if [--print] or [--credential-process] is enabled.
+//
+// If [--write-profile] is enabled, the credentials are also written to the shared
+// credentials file, and the command is optional.
+//
+// If [--fanout] targets are selected, the command runs once per target instead, and if
+// [--shell] is enabled, an interactive shell runs instead of a command.
+//
+// The auth mixin is retained for the duration of the command in modes which renew
+// the credentials, e.g. [--serve-credentials] and [--creds-via=file].
+func (m *Exec) Do(ctx context.Context, a *auth.Mixin, p auth.Provider, args []string) {
+	printMode := m.Print != "" || m.CredentialProcess
+	serveMode := m.ServeCredentials || m.ServeImds
+	fileMode := m.CredsVia == CredsViaFile
+
+	m.ExitOnErrShort(m.checkNestedSession(a), "refusing to run in a nested session", 1)
+
+	if m.Replace && a.RefreshBackground {
+		fmt.Fprintln(m.Err(), "--replace cannot be combined with --refresh-background")
+		os.Exit(1)
+	}
+
+	if m.Shell {
+		args = []string{shellPath()}
+		m.Pty = true
+	}
+
+	if len(args) == 0 && !printMode && m.WriteProfile == "" {
 		fmt.Fprintln(m.Err(), "command not specified")
 		os.Exit(1)
 	}
 
-	var cmd *exec.Cmd
+	if printMode {
+		a.PromptOut = m.Err()
+		a.NonInteractive = m.CredentialProcess
+	}
+
+	if len(m.fanoutTargets) > 0 {
+		stages, stagesErr := SplitPipeline(args, m.PipelineSep)
+		m.ExitOnErr(stagesErr, "invalid pipeline", 1)
+		m.fanout(ctx, a, p, stages)
+		return
+	}
+
+	report := m.newReport(a, p)
 
-	if m.Pty {
-		if len(args) == 1 {
-			cmd = exec.Command(args[0]) // #nosec
-		} else {
-			cmd = exec.Command(args[0], args[1:]...) // #nosec
+	// exitOnErr also completes the [--report], if enabled, before exiting.
+	exitOnErr := func(err error, msg string, code int) {
+		if err != nil {
+			m.writeReport(report, code, errors.Wrap(err, msg))
 		}
-	} else {
+		m.exitOnErr(ctx, a, err, msg, code)
+	}
+
+	// Acquire the credentials once for all modes which use them once.
+	var acquired auth.Result
+	if !(serveMode || fileMode) || m.WriteProfile != "" || m.Template || m.Report != "" {
+		var credsErr error
+		acquired, credsErr = a.CredentialsResult(p, 0)
+		exitOnErr(credsErr, "failed to acquire credentials", 1)
+		report.setCredentials(a, acquired)
+	}
+
+	if m.WriteProfile != "" {
+		exitOnErr(m.writeProfile(acquired.Creds), "failed to write profile", 1)
+	}
+
+	if m.Print != "" {
+		m.exitOnErr(ctx, a, m.print(a, acquired), "failed to print credentials", 1)
+		return
+	}
+	if m.CredentialProcess {
+		m.exitOnErr(ctx, a, m.printCredentialProcess(acquired.Creds), "failed to print credentials", 1)
+		return
+	}
+
+	if len(args) == 0 { // only [--write-profile]
+		return
+	}
+
+	// [--supervise] applies the timeout to each run.
+	if m.Timeout > 0 && !m.Supervise {
 		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(m.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	stages, stagesErr := SplitPipeline(args, m.PipelineSep)
+	exitOnErr(stagesErr, "invalid pipeline", 1)
+
+	if m.Template {
+		var expandErr error
+		stages, expandErr = expandStages(a, acquired, stages)
+		exitOnErr(expandErr, "failed to expand command templates", 1)
+	}
+
+	var cmds []*exec.Cmd
+	for _, stage := range stages {
+		cmds = append(cmds, m.command(stage))
+	}
+
+	// Fail before [--serve-credentials], [--serve-imds], or [--creds-via=file] start, which
+	// would need to be stopped before exiting.
+	in, releaseIn, inErr := m.stdin()
+	exitOnErr(inErr, "failed to open standard input", 1)
+	defer releaseIn()
+
+	var markerEnv []string
+	if m.Shell {
+		var markerErr error
+		markerEnv, markerErr = sessionEnv(a, acquired)
+		exitOnErr(markerErr, "failed to mark the shell session", 1)
+	}
+
+	// A failure of a credentials endpoint stops the command, and the run exits after it does.
+	failure, ctx := newRunFailure(ctx)
+	defer failure.cancel()
+
+	var env []string
+	var started func() // called once the command has started
+	stop := func() {}
 
-		if m.Timeout > 0 {
-			ctx, cancel = context.WithTimeout(ctx, time.Duration(m.Timeout)*time.Second)
-			defer cancel()
+	if serveMode {
+		var serveErr error
+		env, stop, serveErr = m.serveCredentials(a, p, m.baseEnv(), failure)
+		exitOnErr(serveErr, "failed to serve credentials", 1)
+
+		// The credentials, their expiration, and the session name may change during the run.
+		env = append(env, m.renameEnv(metadataEnv(a, ""))...)
+	} else if fileMode {
+		var fileErr error
+		env, started, stop, fileErr = m.credsFile(a, p, m.baseEnv())
+		exitOnErr(fileErr, "failed to write credentials file", 1)
+
+		env = append(env, m.renameEnv(metadataEnv(a, ""))...)
+	} else {
+		credsEnv, envErr := m.credentialsEnv(a, acquired)
+		exitOnErr(envErr, "failed to read credentials", 1)
+
+		env = append(m.baseEnv(), credsEnv...)
+	}
+
+	env = m.overrideEnv(env)
+
+	// The command inherits this process, so nothing after a successful replacement runs.
+	if m.Replace {
+		if m.Cwd != "" {
+			m.ExitOnErrShort(errors.WithStack(os.Chdir(m.Cwd)), "failed to change the working directory", 1)
+		}
+		replaceErr := cage_exec.Replace(env, stages[0][0], stages[0][1:]...)
+		m.ExitOnErrShort(replaceErr, "failed to run command", 127)
+	}
+
+	env = append(env, markerEnv...)
+
+	if m.Supervise {
+		// Each run opens its own standard input, e.g. to read a file from the start.
+		releaseIn()
+
+		nextEnv := func() ([]string, time.Time, error) { return env, time.Time{}, nil }
+		if !(serveMode || fileMode) { // credentials in the environment cannot be renewed during a run
+			nextEnv = m.renewingEnv(a, p)
 		}
 
-		cmd = exec.CommandContext(ctx, args[0], args[1:]...) // #nosec
+		code := m.supervise(ctx, nextEnv, stages, started)
+
+		stop()
+
+		m.exitOnErr(ctx, a, failure.Err(), "failed to serve credentials", 1)
+		m.exit(ctx, a, code)
 	}
 
-	if res, execErr := aws.ExecAs(ctx, creds, m.Out(), m.Err(), m.In(), cmd, m.Pty); execErr != nil {
+	if len(cmds) > 1 {
+		res, execErr := aws.ExecPipelineWithEnv(ctx, env, m.Out(), m.Err(), in, m.stopSignals, started, cmds...)
+
+		stop()
+		releaseIn()
+
+		report.setStages(cmds, res)
+
+		exitOnErr(failure.Err(), "failed to serve credentials", 1)
+
+		if execErr != nil {
+			printPipelineErrors(m.Err(), cmds, res)
+			code := PipelineExitCode(cmds, res)
+			if code == 0 {
+				fmt.Fprintln(m.Err(), execErr)
+				code = 1
+			}
+			m.writeReport(report, code, execErr)
+			m.exit(ctx, a, code)
+		}
+		m.writeReport(report, 0, nil)
+		return
+	}
+
+	cmd := cmds[0]
+	res, execErr := aws.ExecWithEnv(ctx, env, m.Out(), m.Err(), in, m.stopSignals, started, cmd, m.Pty)
+
+	stop()
+	releaseIn()
+
+	report.setStages(cmds, res)
+
+	exitOnErr(failure.Err(), "failed to serve credentials", 1)
+
+	if execErr != nil {
 		fmt.Fprintln(m.Err(), execErr)
-		os.Exit(res.Cmd[cmd].Code)
+		printStopSignal(m.Err(), res.Cmd[cmd])
+		m.writeReport(report, res.Cmd[cmd].ExitCode(), execErr)
+		m.exit(ctx, a, res.Cmd[cmd].ExitCode())
+	}
+	m.writeReport(report, 0, nil)
+}
+
+// exit exits with the code after the background refresh of the credentials, if any,
+// finishes. Otherwise os.Exit would interrupt it, e.g. while it writes the cache.
+func (m *Exec) exit(ctx context.Context, a *auth.Mixin, code int) {
+	a.PostRun(ctx)
+	os.Exit(code)
+}
+
+// exitOnErr is ExitOnErr which first lets the background refresh, if any, finish like exit.
+func (m *Exec) exitOnErr(ctx context.Context, a *auth.Mixin, err error, msg string, code int) {
+	if err == nil {
+		return
 	}
+	a.PostRun(ctx)
+	m.ExitOnErr(err, msg, code)
+}
+
+// command returns the command of one pipeline stage, which runs in [--cwd] if selected.
+//
+// The executor, instead of exec.CommandContext, stops the command after [--timeout].
+func (m *Exec) command(stage []string) *exec.Cmd {
+	cmd := exec.Command(stage[0], stage[1:]...) // #nosec
+	cmd.Dir = m.Cwd
+	return cmd
+}
+
+// stdin returns the [--stdin] selection and a function, which is safe to call more than once,
+// that releases it.
+func (m *Exec) stdin() (io.Reader, func(), error) {
+	if m.Stdin == cage_exec.StdinPipe { // the session may replace the current process's input
+		return m.In(), func() {}, nil
+	}
+
+	in, release, openErr := cage_exec.OpenStdin(m.Stdin, os.Stdin)
+	if openErr != nil {
+		return nil, nil, errors.WithStack(openErr)
+	}
+
+	var once sync.Once
+	return in, func() { once.Do(func() { _ = release() }) }, nil
 }
 
 func New() Exec {
@@ -85,3 +576,4 @@ func New() Exec {
 }
 
 var _ handler.Mixin = (*Exec)(nil)
+var _ handler.PreRun = (*Exec)(nil)
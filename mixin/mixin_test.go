@@ -0,0 +1,305 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/cache"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+	testecho "github.com/codeactual/aws-exec-cmd/internal/cage/cmd/testecho"
+	tp_bytes "github.com/codeactual/aws-exec-cmd/internal/third_party/gist.github.com/bytes"
+	"github.com/codeactual/aws-exec-cmd/mixin"
+)
+
+const sessionArn = "arn:aws:sts::123456789012:assumed-role/name/session"
+
+// provider returns the same credentials for every request.
+type provider struct{}
+
+func (p *provider) Get(input auth.ProviderInput) (auth.Result, error) {
+	return auth.Result{Creds: credentials.NewStaticCredentials("id", "secret", "token"), Arn: sessionArn}, nil
+}
+
+func (p *provider) Name() string {
+	return "test/provider"
+}
+
+// expiringProvider returns credentials, with a new access key ID per request, which expire soon.
+type expiringProvider struct {
+	mu       sync.Mutex
+	requests int
+	ttl      time.Duration
+}
+
+func (p *expiringProvider) Get(input auth.ProviderInput) (auth.Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests++
+
+	v := credentials.Value{AccessKeyID: fmt.Sprintf("key-%d", p.requests), SecretAccessKey: "secret", SessionToken: "token"}
+	creds := credentials.NewCredentials(&expiringValue{value: v, expires: time.Now().Add(p.ttl)})
+	return auth.Result{Creds: creds, Arn: sessionArn}, nil
+}
+
+// expiringValue is a credentials.Provider which reports an expiration.
+type expiringValue struct {
+	credentials.Expiry
+
+	value   credentials.Value
+	expires time.Time
+}
+
+func (v *expiringValue) Retrieve() (credentials.Value, error) {
+	v.SetExpiration(v.expires, 0)
+	return v.value, nil
+}
+
+// envCmd returns a command whose standard output is the environment testecho receives.
+func envCmd() []string {
+	return []string{"sh", "-c", `exec "$0" --stdout "$(env)"`, testecho.Which()}
+}
+
+// setenv sets the variables and returns a function which restores their prior values.
+func setenv(t *testing.T, vars map[string]string) (restore func()) {
+	var restores []func()
+	for name, value := range vars {
+		name := name
+		prev, ok := os.LookupEnv(name)
+		require.NoError(t, os.Setenv(name, value))
+		if ok {
+			restores = append(restores, func() { _ = os.Setenv(name, prev) })
+		} else {
+			restores = append(restores, func() { _ = os.Unsetenv(name) })
+		}
+	}
+	return func() {
+		for _, r := range restores {
+			r()
+		}
+	}
+}
+
+// run runs the command with the flags and credentials from the provider, and returns its standard output.
+func run(t *testing.T, p auth.Provider, flags []string, args ...string) string {
+	m := mixin.New()
+
+	cmd := &cobra.Command{}
+	m.BindCobraFlags(cmd)
+	require.NoError(t, cmd.ParseFlags(flags))
+
+	// Goroutine safe because the commands of a pipeline write to them concurrently.
+	stdout, stderr := tp_bytes.NewSharedBuffer(), tp_bytes.NewSharedBuffer()
+	m.SetOut(stdout)
+	m.SetErr(stderr)
+	m.SetIn(strings.NewReader(""))
+
+	require.NoError(t, m.PreRun(context.Background(), args))
+
+	a := &auth.Mixin{
+		Ctx:           context.Background(),
+		Cache:         cache.NewMemory(),
+		RoleChain:     "test",
+		SessionTtlSec: auth.DefaultSessionTtlSec,
+	}
+	m.Do(context.Background(), a, p, args)
+
+	return stdout.String()
+}
+
+// receivedEnv runs envCmd with the flags and returns the environment testecho received.
+func receivedEnv(t *testing.T, flags ...string) map[string]string {
+	env := make(map[string]string)
+	for _, line := range strings.Split(run(t, &provider{}, flags, envCmd()...), "\n") {
+		if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env
+}
+
+func requireEnv(t *testing.T, env map[string]string, name, expected string) {
+	actual, ok := env[name]
+	require.True(t, ok, name)
+	require.Exactly(t, expected, actual, name)
+}
+
+func requireNoEnv(t *testing.T, env map[string]string, names ...string) {
+	for _, name := range names {
+		_, ok := env[name]
+		require.False(t, ok, name)
+	}
+}
+
+func TestInheritedEnv(t *testing.T) {
+	defer setenv(t, map[string]string{
+		"AWS_PROFILE":                 "dev",
+		"AWS_SHARED_CREDENTIALS_FILE": "/some/file",
+		"MIXIN_TEST_KEPT":             "kept",
+		"MIXIN_TEST_OTHER":            "other",
+	})()
+
+	t.Run("should remove conflicting AWS variables", func(t *testing.T) {
+		env := receivedEnv(t)
+		requireEnv(t, env, "AWS_ACCESS_KEY_ID", "id")
+		requireEnv(t, env, "AWS_SECRET_ACCESS_KEY", "secret")
+		requireEnv(t, env, "AWS_SESSION_TOKEN", "token")
+		requireEnv(t, env, "MIXIN_TEST_OTHER", "other")
+		requireEnv(t, env, mixin.RoleChainEnv, "test")
+		requireNoEnv(t, env, "AWS_PROFILE", "AWS_SHARED_CREDENTIALS_FILE")
+	})
+
+	t.Run("should keep conflicting AWS variables selected by --keep-aws-env", func(t *testing.T) {
+		env := receivedEnv(t, "--keep-aws-env", "AWS_PROFILE")
+		requireEnv(t, env, "AWS_PROFILE", "dev")
+		requireNoEnv(t, env, "AWS_SHARED_CREDENTIALS_FILE")
+	})
+
+	t.Run("should only inherit the default --keep-env variables with --clean-env", func(t *testing.T) {
+		env := receivedEnv(t, "--clean-env")
+		requireEnv(t, env, "PATH", os.Getenv("PATH"))
+		requireEnv(t, env, "AWS_ACCESS_KEY_ID", "id")
+		requireNoEnv(t, env, "MIXIN_TEST_KEPT", "MIXIN_TEST_OTHER", "AWS_PROFILE")
+	})
+
+	t.Run("should only inherit the --keep-env and --keep-aws-env variables with --clean-env", func(t *testing.T) {
+		env := receivedEnv(t, "--clean-env", "--keep-env", "PATH,MIXIN_TEST_KEPT", "--keep-aws-env", "AWS_PROFILE")
+		requireEnv(t, env, "MIXIN_TEST_KEPT", "kept")
+		requireEnv(t, env, "AWS_PROFILE", "dev")
+		requireNoEnv(t, env, "MIXIN_TEST_OTHER", "AWS_SHARED_CREDENTIALS_FILE")
+	})
+
+	t.Run("should not treat --keep-env variables as exempt from removal", func(t *testing.T) {
+		env := receivedEnv(t, "--clean-env", "--keep-env", "PATH,AWS_PROFILE")
+		requireNoEnv(t, env, "AWS_PROFILE")
+	})
+
+	t.Run("should remove --unset-env variables", func(t *testing.T) {
+		env := receivedEnv(t, "--unset-env", "MIXIN_TEST_OTHER")
+		requireEnv(t, env, "MIXIN_TEST_KEPT", "kept")
+		requireNoEnv(t, env, "MIXIN_TEST_OTHER")
+
+		env = receivedEnv(t, "--clean-env", "--keep-env", "PATH,MIXIN_TEST_KEPT", "--unset-env", "MIXIN_TEST_KEPT")
+		requireNoEnv(t, env, "MIXIN_TEST_KEPT")
+	})
+}
+
+func TestCredsFile(t *testing.T) {
+	t.Run("should create, renew, and remove the credentials file", func(t *testing.T) {
+		// Print the file's mode and content, and then its content after a renewal, and its path.
+		script := `f="$AWS_SHARED_CREDENTIALS_FILE"; exec "$0" --stdout "$(stat -c %a "$f"; echo "$AWS_PROFILE"; cat "$f"; sleep 2; echo ---; cat "$f"; echo ---; echo "$f")"`
+		p := &expiringProvider{ttl: 3 * time.Second}
+
+		out := run(t, p, []string{"--creds-via", "file", "--serve-refresh-before", "2"}, "sh", "-c", script, testecho.Which())
+
+		parts := strings.Split(out, "\n---\n")
+		require.Len(t, parts, 3)
+
+		created := strings.SplitN(parts[0], "\n", 3)
+		require.Len(t, created, 3)
+		require.Exactly(t, "600", created[0])
+		require.Exactly(t, "aws-exec-cmd", created[1])
+		require.Contains(t, created[2], "[aws-exec-cmd]")
+		require.Regexp(t, `(?m)^aws_access_key_id\s*=\s*key-1$`, created[2])
+		require.Regexp(t, `(?m)^aws_secret_access_key\s*=\s*secret$`, created[2])
+
+		require.Regexp(t, `(?m)^aws_access_key_id\s*=\s*key-[2-9]$`, parts[1])
+
+		filename := strings.TrimSpace(parts[2])
+		require.NotEmpty(t, filename)
+		_, statErr := os.Stat(filepath.Dir(filename))
+		require.True(t, os.IsNotExist(statErr), filename)
+	})
+}
+
+func TestUserEnv(t *testing.T) {
+	defer setenv(t, map[string]string{"MIXIN_TEST_OTHER": "inherited"})()
+
+	dir, dirErr := ioutil.TempDir("", "aws-exec-cmd-env-")
+	require.NoError(t, dirErr)
+	defer os.RemoveAll(dir)
+
+	envFile := filepath.Join(dir, "vars.env")
+	require.NoError(t, ioutil.WriteFile(envFile, []byte(strings.Join([]string{
+		"FROM_FILE=file",
+		"OVERRIDDEN=file",
+		"MIXIN_TEST_OTHER=file",
+		"AWS_PROFILE=file",
+	}, "\n")), 0600))
+
+	for _, c := range []struct {
+		desc     string
+		flags    []string
+		expected map[string]string
+		absent   []string
+	}{
+		{
+			desc:  "user variables override inherited ones, and --env overrides --env-file",
+			flags: []string{"--env-file", envFile, "--env", "OVERRIDDEN=flag", "--env", "FROM_FLAG=flag"},
+			expected: map[string]string{
+				"FROM_FILE":        "file",
+				"FROM_FLAG":        "flag",
+				"OVERRIDDEN":       "flag",
+				"MIXIN_TEST_OTHER": "file",
+			},
+		},
+		{
+			desc:  "the provided credentials and details override user variables",
+			flags: []string{"--env-file", envFile, "--env", "AWS_ACCESS_KEY_ID=flag", "--env", mixin.RoleChainEnv + "=flag"},
+			expected: map[string]string{
+				"AWS_ACCESS_KEY_ID": "id",
+				mixin.RoleChainEnv:  "test",
+			},
+			absent: []string{"AWS_PROFILE"},
+		},
+		{
+			desc:  "--allow-override lets user variables override the provided credentials and details",
+			flags: []string{"--env-file", envFile, "--env", "AWS_ACCESS_KEY_ID=flag", "--env", mixin.RoleChainEnv + "=flag", "--allow-override"},
+			expected: map[string]string{
+				"AWS_ACCESS_KEY_ID":     "flag",
+				"AWS_SECRET_ACCESS_KEY": "secret",
+				mixin.RoleChainEnv:      "flag",
+				"AWS_PROFILE":           "file",
+			},
+		},
+		{
+			desc:     "--unset-env does not remove user variables",
+			flags:    []string{"--env", "MIXIN_TEST_OTHER=flag", "--unset-env", "MIXIN_TEST_OTHER"},
+			expected: map[string]string{"MIXIN_TEST_OTHER": "flag"},
+		},
+	} {
+		env := receivedEnv(t, c.flags...)
+		for name, value := range c.expected {
+			require.Exactly(t, value, env[name], "%s: %s", c.desc, name)
+		}
+		requireNoEnv(t, env, c.absent...)
+	}
+}
+
+func TestCwd(t *testing.T) {
+	dir, dirErr := ioutil.TempDir("", "aws-exec-cmd-cwd-")
+	require.NoError(t, dirErr)
+	defer os.RemoveAll(dir)
+
+	expected, evalErr := filepath.EvalSymlinks(dir)
+	require.NoError(t, evalErr)
+
+	out := run(t, &provider{}, []string{"--cwd", dir}, "sh", "-c", `exec "$0" --stdout "$(pwd -P)"`, testecho.Which())
+	require.Exactly(t, expected, out)
+}
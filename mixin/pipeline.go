@@ -0,0 +1,87 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/pkg/errors"
+
+	cage_exec "github.com/codeactual/aws-exec-cmd/internal/cage/os/exec"
+)
+
+// SplitPipeline returns the arguments of each pipeline command.
+//
+// An empty separator disables pipelines, returning all arguments as one command.
+func SplitPipeline(args []string, sep string) ([][]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if sep == "" {
+		return [][]string{args}, nil
+	}
+
+	var stages [][]string
+	var stage []string
+
+	for _, arg := range args {
+		if arg == sep {
+			stages = append(stages, stage)
+			stage = nil
+			continue
+		}
+		stage = append(stage, arg)
+	}
+	stages = append(stages, stage)
+
+	for n, s := range stages {
+		if len(s) == 0 {
+			return nil, errors.Errorf("pipeline command %d of %d is empty", n+1, len(stages))
+		}
+	}
+
+	return stages, nil
+}
+
+// PipelineExitCode returns the exit code of the last failed command, like the pipefail
+// option of shells, or 0 if all succeeded.
+//
+// Commands without a result, e.g. because they were not started, are skipped.
+func PipelineExitCode(cmds []*exec.Cmd, res cage_exec.PipelineResult) int {
+	for n := len(cmds) - 1; n >= 0; n-- {
+		r, ok := res.Cmd[cmds[n]]
+		if !ok {
+			continue
+		}
+		if code := r.ExitCode(); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// printPipelineErrors writes the error of each failed command.
+func printPipelineErrors(w io.Writer, cmds []*exec.Cmd, res cage_exec.PipelineResult) {
+	for n, cmd := range cmds {
+		r, ok := res.Cmd[cmd]
+		if !ok || r.Err == nil {
+			continue
+		}
+		fmt.Fprintf(w, "pipeline command %d of %d failed (exit code %d): %s: %s\n", n+1, len(cmds), r.ExitCode(), cage_exec.CmdToString(cmd), r.Err)
+		printStopSignal(w, r)
+	}
+}
+
+// printStopSignal reports the last [--stop-signals] signal sent to the command's process group, if any,
+// e.g. after [--timeout] or after another command of the pipeline failed.
+func printStopSignal(w io.Writer, r cage_exec.Result) {
+	if r.StopSignal != 0 {
+		fmt.Fprintf(w, "command was stopped by --stop-signals, last with SIG%s\n", cage_exec.SignalName(r.StopSignal))
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin_test
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	testecho "github.com/codeactual/aws-exec-cmd/internal/cage/cmd/testecho"
+	cage_exec "github.com/codeactual/aws-exec-cmd/internal/cage/os/exec"
+	"github.com/codeactual/aws-exec-cmd/mixin"
+)
+
+func TestSplitPipeline(t *testing.T) {
+	t.Run("should split commands at the separator", func(t *testing.T) {
+		for _, c := range []struct {
+			args     []string
+			sep      string
+			expected [][]string
+		}{
+			{nil, ":::", nil},
+			{[]string{"echo", "a"}, ":::", [][]string{{"echo", "a"}}},
+			{[]string{"cat", "f", ":::", "gzip", "-d", ":::", "jq", "."}, ":::", [][]string{{"cat", "f"}, {"gzip", "-d"}, {"jq", "."}}},
+			{[]string{"cat", "f", "|", "jq", "."}, "|", [][]string{{"cat", "f"}, {"jq", "."}}},
+			{[]string{"echo", ":::-like", "a:::b"}, ":::", [][]string{{"echo", ":::-like", "a:::b"}}},
+		} {
+			stages, err := mixin.SplitPipeline(c.args, c.sep)
+			require.NoError(t, err, "%v", c.args)
+			require.Exactly(t, c.expected, stages, "%v", c.args)
+		}
+	})
+
+	t.Run("should not split without a separator", func(t *testing.T) {
+		args := []string{"parallel", "echo", ":::", "a", "b"}
+		stages, err := mixin.SplitPipeline(args, "")
+		require.NoError(t, err)
+		require.Exactly(t, [][]string{args}, stages)
+	})
+
+	t.Run("should reject empty commands", func(t *testing.T) {
+		for msg, args := range map[string][]string{
+			"pipeline command 1 of 2 is empty": {":::", "jq", "."},
+			"pipeline command 2 of 2 is empty": {"cat", "f", ":::"},
+			"pipeline command 2 of 3 is empty": {"cat", "f", ":::", ":::", "jq", "."},
+		} {
+			_, err := mixin.SplitPipeline(args, ":::")
+			require.EqualError(t, err, msg)
+		}
+	})
+}
+
+func TestPipeline(t *testing.T) {
+	t.Run("should pass the separator to the command by default", func(t *testing.T) {
+		out := run(t, &provider{}, nil, "sh", "-c", `exec "$0" --stdout "$*"`, testecho.Which(), ":::", "a", "b")
+		require.Exactly(t, "::: a b", out)
+	})
+
+	t.Run("should run a pipeline with --pipeline-sep", func(t *testing.T) {
+		args := append(append([]string{testecho.Which()}, testecho.NewCmdArgs()...), ":::", testecho.Which())
+		args = append(args, testecho.NewCmdArgs(testecho.Input{Stdin: true})...)
+
+		out := run(t, &provider{}, []string{"--pipeline-sep", ":::"}, args...)
+		require.Exactly(t, testecho.DefaultStdoutFromStdin, out)
+	})
+}
+
+func TestPipelineExitCode(t *testing.T) {
+	cmds := []*exec.Cmd{exec.Command("a"), exec.Command("b"), exec.Command("c")}
+
+	for _, c := range []struct {
+		desc     string
+		results  []cage_exec.Result
+		expected int
+	}{
+		{"all succeeded", []cage_exec.Result{{}, {}, {}}, 0},
+		{"last failed", []cage_exec.Result{{}, {}, {Code: 3}}, 3},
+		{"first failed", []cage_exec.Result{{Code: 2}, {}, {}}, 2},
+		{"several failed", []cage_exec.Result{{Code: 2}, {Code: 5}, {}}, 5},
+		{"signaled", []cage_exec.Result{{}, {Code: -1, Signal: syscall.SIGPIPE}, {}}, 128 + int(syscall.SIGPIPE)},
+		{"no result", []cage_exec.Result{{Code: 4}}, 4},
+	} {
+		res := cage_exec.PipelineResult{Cmd: map[*exec.Cmd]cage_exec.Result{}}
+		for n, r := range c.results {
+			res.Cmd[cmds[n]] = r
+		}
+		require.Exactly(t, c.expected, mixin.PipelineExitCode(cmds, res), c.desc)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pkg/errors"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/os/shell"
+)
+
+// PrintEnvNames holds the variables written by [--print], without [--env-name]/[--env-prefix]
+// renaming, and cleared by PrintUnset.
+//
+// The region variables are omitted because they may have been set before [--print] was used.
+var PrintEnvNames = []string{
+	aws.AccessKeyIDEnv,
+	aws.SecretAccessKeyEnv,
+	aws.SessionTokenEnv,
+	aws.CredentialExpirationEnv,
+	AccountIDEnv,
+	RoleArnEnv,
+	RoleSessionNameEnv,
+	RoleChainEnv,
+}
+
+// print writes the credentials, in the [--print] format, instead of running a command.
+//
+// Do writes the MFA prompt, if any, to standard error so that the output can be evaluated
+// by a shell, e.g. `eval "$(aws-exec-cmd role --chain ... --print sh)"`.
+func (m *Exec) print(a *auth.Mixin, res auth.Result) error {
+	env, envErr := m.credentialsEnv(a, res)
+	if envErr != nil {
+		return errors.Wrap(envErr, "failed to read credentials")
+	}
+
+	return errors.WithStack(shell.Export(m.Out(), m.Print, env))
+}
+
+// credentialProcessVersion is the supported version of the credential_process output.
+const credentialProcessVersion = 1
+
+// credentialProcessOutput is the document which SDKs read from a credential_process.
+//
+// https://docs.aws.amazon.com/cli/latest/topic/config-vars.html#sourcing-credentials-from-external-processes
+type credentialProcessOutput struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string `json:",omitempty"`
+	Expiration      string `json:",omitempty"`
+}
+
+// printCredentialProcess writes the credentials as a credential_process document.
+//
+// SDKs run the process without a terminal, so Do disables the MFA prompt and [--mfa-command]
+// or [--mfa-source] must provide codes if the cached credentials cannot be used.
+func (m *Exec) printCredentialProcess(creds *credentials.Credentials) error {
+	v, credsErr := creds.Get()
+	if credsErr != nil {
+		return errors.Wrap(credsErr, "failed to read credentials")
+	}
+
+	out := credentialProcessOutput{
+		Version:         credentialProcessVersion,
+		AccessKeyId:     v.AccessKeyID,
+		SecretAccessKey: v.SecretAccessKey,
+		SessionToken:    v.SessionToken,
+	}
+	if expiration, ok := expiring.ExpiresAt(creds); ok {
+		out.Expiration = expiration.UTC().Format(time.RFC3339)
+	}
+
+	return errors.WithStack(json.NewEncoder(m.Out()).Encode(out))
+}
+
+// PrintUnset writes statements, in the selected shell.Formats format, which clear the
+// variables written by [--print].
+func PrintUnset(w io.Writer, format string) error {
+	return errors.WithStack(shell.Unset(w, format, PrintEnvNames))
+}
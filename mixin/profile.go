@@ -0,0 +1,38 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pkg/errors"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/shared"
+)
+
+// writeProfile merges the credentials into the [--write-profile] profile of the shared
+// credentials file selected by AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials.
+func (m *Exec) writeProfile(creds *credentials.Credentials) error {
+	v, credsErr := creds.Get()
+	if credsErr != nil {
+		return errors.Wrap(credsErr, "failed to read credentials")
+	}
+
+	var expiration time.Time
+	if expires, ok := expiring.ExpiresAt(creds); ok {
+		expiration = expires
+	}
+
+	filename, filenameErr := shared.DefaultFilename()
+	if filenameErr != nil {
+		return errors.WithStack(filenameErr)
+	}
+
+	return errors.WithStack(shared.WriteProfile(filename, m.WriteProfile, shared.CredentialsProperties(v, expiration)))
+}
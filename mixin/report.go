@@ -0,0 +1,160 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+	cage_exec "github.com/codeactual/aws-exec-cmd/internal/cage/os/exec"
+)
+
+// runReport is the [--report] file content.
+//
+// Times use RFC 3339 format in UTC.
+type runReport struct {
+	// Provider is the credentials source, e.g. "role" or "idp".
+	Provider string `json:"provider"`
+
+	Chain string `json:"chain,omitempty"`
+
+	// CacheHit is true if the credentials were read from the cache instead of the provider.
+	CacheHit bool `json:"cache_hit"`
+
+	Identity *principal `json:"identity,omitempty"`
+	Expires  string     `json:"expires,omitempty"`
+
+	Start      string `json:"start"`
+	End        string `json:"end"`
+	DurationMs int64  `json:"duration_ms"`
+
+	// ExitCode is the exit status of aws-exec-cmd as seen by its parent, e.g. 255 for os.Exit(-1).
+	ExitCode int `json:"exit_code"`
+
+	// Error describes why the run failed before or while running the command, if it did.
+	Error string `json:"error,omitempty"`
+
+	// Stages describes each command of the pipeline, or the only command, in order.
+	Stages []reportStage `json:"stages,omitempty"`
+
+	start time.Time
+}
+
+// reportStage describes one command of the [--report] run.
+type reportStage struct {
+	Args     []string `json:"args"`
+	ExitCode int      `json:"exit_code"`
+
+	// Pid and Pgid are -1 if the command did not start.
+	Pid  int `json:"pid"`
+	Pgid int `json:"pgid"`
+
+	// Signal terminated the command, e.g. "SIGTERM", if any.
+	Signal string `json:"signal,omitempty"`
+
+	// StopSignal is the last [--stop-signals] signal sent to the command's process group, if any.
+	StopSignal string `json:"stop_signal,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// newReport starts the [--report] of a run, or returns nil if it is disabled.
+func (m *Exec) newReport(a *auth.Mixin, p auth.Provider) *runReport {
+	if m.Report == "" {
+		return nil
+	}
+
+	r := &runReport{Chain: a.RoleChain, start: time.Now()}
+	if named, ok := p.(interface{ Name() string }); ok {
+		r.Provider = path.Base(named.Name())
+	}
+	return r
+}
+
+// setCredentials records the acquired credentials and their identity.
+func (r *runReport) setCredentials(a *auth.Mixin, res auth.Result) {
+	if r == nil || res.Creds == nil {
+		return
+	}
+
+	r.CacheHit = res.Cached
+
+	if pr := newPrincipal(a, res.Arn); pr != (principal{}) {
+		r.Identity = &pr
+	}
+
+	if expires, ok := expiring.ExpiresAt(res.Creds); ok {
+		r.Expires = expires.UTC().Format(time.RFC3339)
+	}
+}
+
+// setStages records the result of each command.
+func (r *runReport) setStages(cmds []*exec.Cmd, res cage_exec.PipelineResult) {
+	if r == nil {
+		return
+	}
+
+	r.Stages = nil
+	for _, cmd := range cmds {
+		cmdRes := res.Cmd[cmd]
+
+		stage := reportStage{
+			Args:     cmd.Args,
+			ExitCode: cmdRes.ExitCode(),
+			Pid:      cmdRes.Pid,
+			Pgid:     cmdRes.Pgid,
+		}
+		if cmdRes.Signal != 0 {
+			stage.Signal = "SIG" + cage_exec.SignalName(cmdRes.Signal)
+		}
+		if cmdRes.StopSignal != 0 {
+			stage.StopSignal = "SIG" + cage_exec.SignalName(cmdRes.StopSignal)
+		}
+		if cmdRes.Err != nil {
+			stage.Error = cmdRes.Err.Error()
+		}
+
+		r.Stages = append(r.Stages, stage)
+	}
+}
+
+// writeReport completes the [--report] with the exit code and error, if any, and writes it.
+//
+// A failure to write the report is only printed, so the run's exit code is unchanged.
+func (m *Exec) writeReport(r *runReport, code int, err error) {
+	if r == nil {
+		return
+	}
+
+	end := time.Now()
+
+	r.Start = r.start.UTC().Format(time.RFC3339Nano)
+	r.End = end.UTC().Format(time.RFC3339Nano)
+	r.DurationMs = end.Sub(r.start).Nanoseconds() / int64(time.Millisecond)
+	r.ExitCode = code & 0xff
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	data, marshalErr := json.MarshalIndent(r, "", "  ")
+	if marshalErr != nil {
+		fmt.Fprintf(m.Err(), "failed to encode report: %+v\n", errors.WithStack(marshalErr))
+		return
+	}
+
+	if writeErr := ioutil.WriteFile(m.Report, append(data, '\n'), 0644); writeErr != nil { // #nosec
+		fmt.Fprintf(m.Err(), "failed to write report [%s]: %+v\n", m.Report, errors.WithStack(writeErr))
+	}
+}
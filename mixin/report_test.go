@@ -0,0 +1,187 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	testecho "github.com/codeactual/aws-exec-cmd/internal/cage/cmd/testecho"
+	cage_exec "github.com/codeactual/aws-exec-cmd/internal/cage/os/exec"
+	"github.com/codeactual/aws-exec-cmd/mixin"
+)
+
+// report holds the [--report] fields asserted by the tests.
+type report struct {
+	Provider string `json:"provider"`
+	Chain    string `json:"chain"`
+	CacheHit bool   `json:"cache_hit"`
+
+	Identity *struct {
+		Arn         string `json:"arn"`
+		AccountID   string `json:"account_id"`
+		RoleName    string `json:"role_name"`
+		SessionName string `json:"session_name"`
+	} `json:"identity"`
+
+	Start      string  `json:"start"`
+	End        string  `json:"end"`
+	DurationMs int64   `json:"duration_ms"`
+	ExitCode   int     `json:"exit_code"`
+	Error      string  `json:"error"`
+	Stages     []stage `json:"stages"`
+}
+
+type stage struct {
+	Args       []string `json:"args"`
+	ExitCode   int      `json:"exit_code"`
+	Pid        int      `json:"pid"`
+	Pgid       int      `json:"pgid"`
+	Signal     string   `json:"signal"`
+	StopSignal string   `json:"stop_signal"`
+	Error      string   `json:"error"`
+}
+
+// tempReport returns the path of a [--report] file and a function which removes its dir.
+func tempReport(t *testing.T) (filename string, remove func()) {
+	dir, err := ioutil.TempDir("", "aws-exec-cmd-report-")
+	require.NoError(t, err)
+	return filepath.Join(dir, "report.json"), func() { _ = os.RemoveAll(dir) }
+}
+
+func readReport(t *testing.T, filename string) report {
+	data, err := ioutil.ReadFile(filename) // #nosec
+	require.NoError(t, err)
+
+	var r report
+	require.NoError(t, json.Unmarshal(data, &r))
+	return r
+}
+
+func TestReport(t *testing.T) {
+	t.Run("should describe a successful run", func(t *testing.T) {
+		filename, remove := tempReport(t)
+		defer remove()
+
+		args := append([]string{testecho.Which()}, testecho.NewCmdArgs()...)
+		run(t, &provider{}, []string{"--report", filename}, args...)
+
+		r := readReport(t, filename)
+		require.Exactly(t, "provider", r.Provider)
+		require.Exactly(t, "test", r.Chain)
+		require.False(t, r.CacheHit)
+		require.NotNil(t, r.Identity)
+		require.Exactly(t, sessionArn, r.Identity.Arn)
+		require.Exactly(t, "123456789012", r.Identity.AccountID)
+		require.Exactly(t, "name", r.Identity.RoleName)
+		require.Exactly(t, "session", r.Identity.SessionName)
+		require.Exactly(t, 0, r.ExitCode)
+		require.Empty(t, r.Error)
+
+		start, startErr := time.Parse(time.RFC3339Nano, r.Start)
+		require.NoError(t, startErr)
+		end, endErr := time.Parse(time.RFC3339Nano, r.End)
+		require.NoError(t, endErr)
+		require.False(t, end.Before(start))
+
+		require.Len(t, r.Stages, 1)
+		require.Exactly(t, args, r.Stages[0].Args)
+		require.Exactly(t, 0, r.Stages[0].ExitCode)
+		require.True(t, r.Stages[0].Pid > 0)
+		require.True(t, r.Stages[0].Pgid > 0)
+	})
+
+	t.Run("should describe each command's result", func(t *testing.T) {
+		cmds := []*exec.Cmd{exec.Command("a", "1"), exec.Command("b"), exec.Command("c")}
+
+		for _, c := range []struct {
+			desc     string
+			results  []cage_exec.Result
+			code     int
+			err      error
+			expected report
+		}{
+			{
+				desc:    "success",
+				results: []cage_exec.Result{{Pid: 10, Pgid: 10}, {Pid: 11, Pgid: 10}, {Pid: 12, Pgid: 10}},
+				expected: report{Stages: []stage{
+					{Args: []string{"a", "1"}, Pid: 10, Pgid: 10},
+					{Args: []string{"b"}, Pid: 11, Pgid: 10},
+					{Args: []string{"c"}, Pid: 12, Pgid: 10},
+				}},
+			},
+			{
+				desc: "failure",
+				results: []cage_exec.Result{
+					{Pid: 10, Pgid: 10, Code: 3, Err: errors.New("exit status 3")},
+					{Pid: 11, Pgid: 10, Code: -1, Signal: syscall.SIGTERM, StopSignal: syscall.SIGTERM, Err: errors.New("signal: terminated")},
+					{Pid: -1, Pgid: -1, Code: -1, Err: errors.New("not found")},
+				},
+				code: 143,
+				err:  errors.New("command failed"),
+				expected: report{ExitCode: 143, Error: "command failed", Stages: []stage{
+					{Args: []string{"a", "1"}, ExitCode: 3, Pid: 10, Pgid: 10, Error: "exit status 3"},
+					{Args: []string{"b"}, ExitCode: 143, Pid: 11, Pgid: 10, Signal: "SIGTERM", StopSignal: "SIGTERM", Error: "signal: terminated"},
+					{Args: []string{"c"}, ExitCode: -1, Pid: -1, Pgid: -1, Error: "not found"},
+				}},
+			},
+			{
+				desc:     "exit status as seen by the parent",
+				results:  []cage_exec.Result{{}, {}, {}},
+				code:     -1,
+				err:      errors.New("failed"),
+				expected: report{ExitCode: 255, Error: "failed"},
+			},
+		} {
+			filename, remove := tempReport(t)
+
+			m := mixin.New()
+			m.Report = filename
+			var stderr bytes.Buffer
+			m.SetErr(&stderr)
+
+			res := cage_exec.PipelineResult{Cmd: map[*exec.Cmd]cage_exec.Result{}}
+			for n, r := range c.results {
+				res.Cmd[cmds[n]] = r
+			}
+
+			start := time.Now().Add(-time.Second)
+			m.WriteStagesReport(start, cmds, res, c.code, c.err)
+
+			r := readReport(t, filename)
+			remove()
+
+			require.Empty(t, stderr.String(), c.desc)
+			require.Exactly(t, c.expected.ExitCode, r.ExitCode, c.desc)
+			require.Exactly(t, c.expected.Error, r.Error, c.desc)
+			require.True(t, r.DurationMs >= 1000, c.desc)
+			if c.expected.Stages != nil {
+				require.Exactly(t, c.expected.Stages, r.Stages, c.desc)
+			}
+		}
+	})
+
+	t.Run("should print a failure to write the report", func(t *testing.T) {
+		m := mixin.New()
+		m.Report = filepath.Join("/nonexistent", "report.json")
+		var stderr bytes.Buffer
+		m.SetErr(&stderr)
+
+		m.WriteStagesReport(time.Now(), nil, cage_exec.PipelineResult{}, 0, nil)
+		require.Contains(t, stderr.String(), "failed to write report [/nonexistent/report.json]")
+	})
+}
@@ -0,0 +1,203 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/pkg/errors"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/server"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+	cage_crypto "github.com/codeactual/aws-exec-cmd/internal/cage/crypto"
+)
+
+const (
+	// serveCredentialsPath is the URL path of the container credentials endpoint.
+	serveCredentialsPath = "/credentials"
+
+	// serveAuthTokenBytes is the length of the random authorization token before hex encoding.
+	serveAuthTokenBytes = 32
+
+	defaultImdsRoleName = "aws-exec-cmd"
+)
+
+// serveCredentialsConflictEnv holds variables which SDKs would select over the endpoint.
+var serveCredentialsConflictEnv = []string{
+	aws.AccessKeyIDEnv,
+	aws.SecretAccessKeyEnv,
+	aws.SessionTokenEnv,
+	server.ContainerRelativeURIEnv,
+	server.ContainerFullURIEnv,
+	server.ContainerAuthTokenEnv,
+}
+
+// serveImdsConflictEnv holds variables which SDKs would select over, or which would disable,
+// the metadata service emulator.
+var serveImdsConflictEnv = append(
+	[]string{server.IMDSEndpointEnv, server.IMDSDisabledEnv},
+	serveCredentialsConflictEnv...,
+)
+
+// runFailure records the first failure of a task which runs in the background alongside the command,
+// e.g. a credentials endpoint, and cancels the command's context so that the command stops.
+//
+// The run exits after the executor returns, instead of from the task's goroutine, so that
+// the command is not orphaned and cleanup, e.g. of a credentials file, still runs.
+type runFailure struct {
+	cancel func()
+
+	mu  sync.Mutex
+	err error
+}
+
+// newRunFailure returns a runFailure and the command's context, which it cancels.
+func newRunFailure(ctx context.Context) (*runFailure, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &runFailure{cancel: cancel}, ctx
+}
+
+// fail records the error, unless one was recorded already, and stops the command.
+func (f *runFailure) fail(err error) {
+	f.mu.Lock()
+	if f.err == nil {
+		f.err = err
+	}
+	f.mu.Unlock()
+
+	f.cancel()
+}
+
+// Err returns the recorded failure, if any.
+func (f *runFailure) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// serveCredentials starts localhost endpoints which serve renewable credentials using the
+// ECS container credentials protocol ([--serve-credentials]) and/or the EC2 instance metadata
+// service protocol ([--serve-imds]).
+//
+// It returns the command's environment, based on the inherited variables in base, which points
+// SDKs to the endpoints, and a function which stops the endpoints. If an endpoint fails while
+// the command runs, the failure is recorded and the command is stopped.
+//
+// Only the initial credentials may be acquired with an MFA prompt. Renewals run while the command
+// owns the terminal, so [--mfa-command] or [--mfa-source] must provide their codes.
+func (m *Exec) serveCredentials(a *auth.Mixin, p auth.Provider, base []string, failure *runFailure) (env []string, stop func(), err error) {
+	started := false
+
+	refresher := server.NewRefresher(
+		func(minRemaining time.Duration) (*credentials.Credentials, error) {
+			if started {
+				return a.RenewCredentials(p, minRemaining)
+			}
+			return a.CredentialsMinRemaining(p, minRemaining)
+		},
+		time.Duration(m.ServeRefreshBeforeSec)*time.Second,
+	)
+
+	// Fail before the command starts if the initial credentials are unavailable.
+	if _, _, getErr := refresher.Get(); getErr != nil {
+		return nil, nil, errors.WithStack(getErr)
+	}
+
+	// The endpoints, which start below, only renew the credentials.
+	started = true
+
+	env = base
+	var servers []*http.Server
+
+	// The command has finished by the time stop is called, so a failure to close an endpoint
+	// is only reported instead of replacing the command's exit code.
+	stop = func() {
+		for _, srv := range servers {
+			if closeErr := srv.Close(); closeErr != nil {
+				fmt.Fprintf(m.Err(), "warning: failed to stop credentials endpoint: %+v\n", errors.WithStack(closeErr))
+			}
+		}
+	}
+
+	if m.ServeImds {
+		if !m.ImdsRequireToken {
+			fmt.Fprintln(m.Err(), "warning: --serve-imds allows IMDSv1 requests, which need no session token, e.g. from a server-side request forgery in any local process (see --imds-require-token)")
+		}
+
+		handler := &server.IMDSHandler{
+			Refresher:    refresher,
+			RoleName:     m.ImdsRoleName,
+			RequireToken: m.ImdsRequireToken,
+			Err:          m.Err(),
+		}
+
+		srv, addr, listenErr := m.listen(handler, failure)
+		if listenErr != nil {
+			stop()
+			return nil, nil, errors.WithStack(listenErr)
+		}
+		servers = append(servers, srv)
+
+		env = append(
+			withoutEnv(env, serveImdsConflictEnv...),
+			server.IMDSEndpointEnv+"=http://"+addr+"/",
+		)
+	}
+
+	if m.ServeCredentials {
+		token, tokenErr := cage_crypto.RandHexString(serveAuthTokenBytes)
+		if tokenErr != nil {
+			stop()
+			return nil, nil, errors.Wrap(tokenErr, "failed to generate authorization token")
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle(serveCredentialsPath, &server.ContainerHandler{Refresher: refresher, AuthToken: token, Err: m.Err()})
+
+		srv, addr, listenErr := m.listen(mux, failure)
+		if listenErr != nil {
+			stop()
+			return nil, nil, errors.WithStack(listenErr)
+		}
+		servers = append(servers, srv)
+
+		env = append(
+			withoutEnv(env, serveCredentialsConflictEnv...),
+			server.ContainerFullURIEnv+"=http://"+addr+serveCredentialsPath,
+			server.ContainerAuthTokenEnv+"="+token,
+		)
+	}
+
+	return env, stop, nil
+}
+
+// listen serves the handler from a random localhost port and returns the server and its address.
+//
+// If the server fails, the failure is recorded.
+func (m *Exec) listen(handler http.Handler, failure *runFailure) (*http.Server, string, error) {
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		return nil, "", errors.Wrap(listenErr, "failed to listen on localhost")
+	}
+
+	srv := &http.Server{Handler: handler}
+
+	go func() {
+		if serveErr := srv.Serve(listener); serveErr != nil && serveErr != http.ErrServerClosed {
+			failure.fail(errors.Wrap(serveErr, "credentials endpoint failed"))
+		}
+	}()
+
+	return srv, listener.Addr().String(), nil
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
+	cage_sts "github.com/codeactual/aws-exec-cmd/internal/cage/aws/v1/sts"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+)
+
+const (
+	// SessionEnv marks a [--shell] session with a JSON object which describes its credentials,
+	// e.g. {"chain":"instance,arn:aws:iam::123456789012:role/backup","account":"123456789012","expires":"2019-01-02T03:04:05Z"}.
+	SessionEnv = "AWS_EXEC_CMD_SESSION"
+
+	// PromptEnv holds a short label of a [--shell] session's principal for use in a prompt,
+	// e.g. "123456789012/backup".
+	PromptEnv = "AWS_EXEC_CMD_PROMPT"
+
+	// defaultShell is used if SHELL is unset.
+	defaultShell = "/bin/sh"
+)
+
+// sessionMarker is the SessionEnv value.
+type sessionMarker struct {
+	Chain   string `json:"chain,omitempty"`
+	Account string `json:"account,omitempty"`
+
+	// Expires uses RFC 3339 format in UTC. It is omitted if unknown, e.g. if the credentials
+	// are renewed during the session.
+	Expires string `json:"expires,omitempty"`
+}
+
+// String returns a description of the session for messages.
+func (s sessionMarker) String() string {
+	var parts []string
+	if s.Chain != "" {
+		parts = append(parts, "chain ["+s.Chain+"]")
+	}
+	if s.Account != "" {
+		parts = append(parts, "account ["+s.Account+"]")
+	}
+	if s.Expires != "" {
+		parts = append(parts, "expires ["+s.Expires+"]")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// shellPath returns the [--shell] program.
+func shellPath() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return defaultShell
+}
+
+// sessionEnv returns the variables which mark a [--shell] session.
+//
+// The expiration is omitted if unknown, e.g. if the credentials are renewed during the session.
+func sessionEnv(a *auth.Mixin, res auth.Result) ([]string, error) {
+	pr := newPrincipal(a, res.Arn)
+
+	marker := sessionMarker{Chain: a.RoleChain, Account: pr.AccountID}
+	if res.Creds != nil {
+		if expires, ok := expiring.ExpiresAt(res.Creds); ok {
+			marker.Expires = expires.UTC().Format(time.RFC3339)
+		}
+	}
+
+	value, marshalErr := json.Marshal(marker)
+	if marshalErr != nil {
+		return nil, errors.Wrap(marshalErr, "failed to encode session marker")
+	}
+
+	prompt := a.RoleChain
+	switch {
+	case pr.AccountID != "" && pr.RoleName != "":
+		prompt = pr.AccountID + "/" + pr.RoleName
+	case pr.AccountID != "":
+		prompt = pr.AccountID
+	}
+
+	return []string{SessionEnv + "=" + string(value), PromptEnv + "=" + prompt}, nil
+}
+
+// checkNestedSession returns an error if the process runs inside a [--shell] session and
+// the role chain is seeded by the environment, whose variables hold the session's credentials.
+// Otherwise it only writes a warning to standard error.
+//
+// [--allow-nested] disables the check.
+func (m *Exec) checkNestedSession(a *auth.Mixin) error {
+	value := os.Getenv(SessionEnv)
+	if value == "" || m.AllowNested {
+		return nil
+	}
+
+	var marker sessionMarker
+	if decodeErr := json.Unmarshal([]byte(value), &marker); decodeErr != nil {
+		marker = sessionMarker{}
+	}
+
+	desc := "an aws-exec-cmd session is active"
+	if s := marker.String(); s != "" {
+		desc += " (" + s + ")"
+	}
+
+	seed := strings.TrimSpace(strings.Split(a.RoleChain, ",")[0])
+	if seed == cage_sts.EnvTempRoleChainAlias {
+		return errors.Errorf("%s and its credentials would seed the %s chain (exit the session or use --allow-nested)", desc, cage_sts.EnvTempRoleChainAlias)
+	}
+
+	fmt.Fprintf(m.Err(), "warning: %s\n", desc)
+
+	return nil
+}
@@ -0,0 +1,330 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+	cage_exec "github.com/codeactual/aws-exec-cmd/internal/cage/os/exec"
+)
+
+const (
+	// RestartAlways restarts a [--supervise] command whenever it exits.
+	RestartAlways = "always"
+
+	// RestartOnFailure restarts a [--supervise] command only if it exits with a non-zero code.
+	RestartOnFailure = "on-failure"
+
+	defaultRestartBackoffSec    = 1
+	defaultRestartBackoffMaxSec = 60
+
+	// superviseMinRenewDelay keeps credentials which already need renewal from restarting
+	// the command in a tight loop.
+	superviseMinRenewDelay = 10 * time.Second
+)
+
+// superviseEnv returns the environment of the next [--supervise] run and the time the command
+// must be restarted with renewed credentials, if any.
+type superviseEnv func() (env []string, renewAt time.Time, err error)
+
+// renewingEnv returns a superviseEnv which acquires credentials, which expire in more than
+// [--serve-refresh-before] seconds, for each run and restarts the command before they expire.
+func (m *Exec) renewingEnv(a *auth.Mixin, p auth.Provider) superviseEnv {
+	before := time.Duration(m.ServeRefreshBeforeSec) * time.Second
+
+	return func() ([]string, time.Time, error) {
+		res, credsErr := a.CredentialsResult(p, before)
+		if credsErr != nil {
+			return nil, time.Time{}, errors.Wrap(credsErr, "failed to acquire credentials")
+		}
+
+		credsEnv, envErr := m.credentialsEnv(a, res)
+		if envErr != nil {
+			return nil, time.Time{}, errors.Wrap(envErr, "failed to read credentials")
+		}
+
+		var renewAt time.Time
+		if expires, ok := expiring.ExpiresAt(res.Creds); ok {
+			renewAt = expires.Add(-before)
+		}
+
+		return m.overrideEnv(append(m.baseEnv(), credsEnv...)), renewAt, nil
+	}
+}
+
+// restartPolicy decides whether, and when, [--supervise] restarts the command after each run.
+//
+// Restarts are delayed by [--restart-backoff], doubled after each consecutive failed run
+// up to [--restart-backoff-max]. A successful run, or one which lasts at least that long, resets the delay.
+// Restarts which renew credentials are neither delayed nor counted toward [--max-restarts].
+type restartPolicy struct {
+	restart     string
+	backoff     time.Duration
+	backoffMax  time.Duration
+	maxRestarts int
+
+	// restarts counts the restarts which did not renew credentials.
+	restarts int
+
+	// failures counts the consecutive failed runs since the delay was last reset.
+	failures int
+}
+
+// restartDecision is the restartPolicy outcome of one run.
+type restartDecision struct {
+	restart bool
+	delay   time.Duration
+
+	// reason describes the decision for the log.
+	reason string
+}
+
+// restartPolicy returns the policy selected by the flags.
+func (m *Exec) restartPolicy() *restartPolicy {
+	return &restartPolicy{
+		restart:     m.Restart,
+		backoff:     time.Duration(m.RestartBackoffSec) * time.Second,
+		backoffMax:  time.Duration(m.RestartBackoffMaxSec) * time.Second,
+		maxRestarts: m.MaxRestarts,
+	}
+}
+
+// next returns the decision after a run which ended with the exit code after it lasted for the duration,
+// or which was stopped to renew its credentials.
+func (p *restartPolicy) next(code int, renewed bool, duration time.Duration) restartDecision {
+	if renewed {
+		return restartDecision{restart: true, reason: "restarting the command with renewed credentials"}
+	}
+
+	if code == 0 && p.restart == RestartOnFailure {
+		return restartDecision{reason: fmt.Sprintf("run ended with code 0; not restarting the command (--restart %s)", p.restart)}
+	}
+
+	if p.maxRestarts > 0 && p.restarts >= p.maxRestarts {
+		return restartDecision{reason: fmt.Sprintf("run ended with code %d; not restarting the command after %d restarts (--max-restarts)", code, p.restarts)}
+	}
+
+	if code == 0 || duration >= p.backoffMax {
+		p.failures = 0
+	}
+
+	delay := p.backoff
+	for n := 0; n < p.failures && delay < p.backoffMax; n++ {
+		delay *= 2
+	}
+	if delay > p.backoffMax {
+		delay = p.backoffMax
+	}
+
+	if code != 0 {
+		p.failures++
+	}
+	p.restarts++
+
+	limit := "unlimited"
+	if p.maxRestarts > 0 {
+		limit = strconv.Itoa(p.maxRestarts)
+	}
+
+	return restartDecision{
+		restart: true,
+		delay:   delay,
+		reason:  fmt.Sprintf("run ended with code %d; restarting the command in %s (restart %d of %s)", code, delay, p.restarts, limit),
+	}
+}
+
+// supervise runs the command, or pipeline, until the [--restart] policy or [--max-restarts]
+// ends the run, and returns the exit code of the last run.
+//
+// A terminating signal stops the running command, to which it is also forwarded, and ends the
+// supervision. If it arrives before the command starts, e.g. during a restart delay,
+// the exit code is 128 plus the signal number.
+//
+// If started is not nil, it is called once this function handles terminating signals.
+func (m *Exec) supervise(ctx context.Context, nextEnv superviseEnv, stages [][]string, started func()) int {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, cage_exec.TerminatingSignals...)
+	defer signal.Stop(sigCh)
+
+	if started != nil {
+		started()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var sigMu sync.Mutex
+	var sig syscall.Signal
+
+	go func() {
+		select {
+		case received := <-sigCh:
+			sigMu.Lock()
+			sig = received.(syscall.Signal)
+			sigMu.Unlock()
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	signaled := func() syscall.Signal {
+		sigMu.Lock()
+		defer sigMu.Unlock()
+		return sig
+	}
+
+	policy := m.restartPolicy()
+	code := 0
+
+	for {
+		if s := signaled(); s != 0 {
+			m.superviseLog("received SIG%s; not restarting the command", cage_exec.SignalName(s))
+			return 128 + int(s)
+		}
+		if ctx.Err() != nil { // e.g. a credentials endpoint failed
+			return code
+		}
+
+		run := m.superviseRun(ctx, nextEnv, stages)
+		code = run.code
+
+		if s := signaled(); s != 0 && run.ran {
+			m.superviseLog("received SIG%s; not restarting the command", cage_exec.SignalName(s))
+			return code
+		}
+		if ctx.Err() != nil { // e.g. a signal arrived before the command started
+			continue
+		}
+
+		decision := policy.next(code, run.renewed, run.duration)
+		m.superviseLog("%s", decision.reason)
+		if !decision.restart {
+			return code
+		}
+
+		if decision.delay > 0 {
+			select {
+			case <-ctx.Done(): // checked at the start of the next iteration
+			case <-time.After(decision.delay):
+			}
+		}
+	}
+}
+
+// superviseRunResult describes one [--supervise] run.
+type superviseRunResult struct {
+	code int
+
+	// renewed is true if the command was stopped to renew its credentials.
+	renewed bool
+
+	// ran is false if the run ended before the command was executed, e.g. because
+	// the context was done.
+	ran bool
+
+	duration time.Duration
+}
+
+// superviseRun runs the command once, unless the context is done before it starts.
+func (m *Exec) superviseRun(ctx context.Context, nextEnv superviseEnv, stages [][]string) (res superviseRunResult) {
+	start := time.Now()
+	defer func() {
+		res.duration = time.Since(start)
+	}()
+
+	env, renewAt, envErr := nextEnv()
+	if envErr != nil {
+		fmt.Fprintln(m.Err(), envErr)
+		return superviseRunResult{code: 1}
+	}
+
+	if ctx.Err() != nil {
+		return superviseRunResult{code: 1}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if m.Timeout > 0 {
+		runCtx, cancel = context.WithTimeout(runCtx, time.Duration(m.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	renewCh := make(chan struct{})
+	if !renewAt.IsZero() {
+		delay := time.Until(renewAt)
+		if delay < superviseMinRenewDelay {
+			delay = superviseMinRenewDelay
+		}
+		timer := time.AfterFunc(delay, func() {
+			close(renewCh)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+
+	in, releaseIn, inErr := m.stdin()
+	if inErr != nil {
+		fmt.Fprintf(m.Err(), "failed to open standard input: %s\n", inErr)
+		return superviseRunResult{code: 1}
+	}
+	defer releaseIn()
+
+	var cmds []*exec.Cmd
+	for _, stage := range stages {
+		cmds = append(cmds, m.command(stage))
+	}
+
+	var pipelineRes cage_exec.PipelineResult
+	var execErr error
+	if len(cmds) > 1 {
+		pipelineRes, execErr = aws.ExecPipelineWithEnv(runCtx, env, m.Out(), m.Err(), in, m.stopSignals, nil, cmds...)
+	} else {
+		pipelineRes, execErr = aws.ExecWithEnv(runCtx, env, m.Out(), m.Err(), in, m.stopSignals, nil, cmds[0], m.Pty)
+	}
+
+	select {
+	case <-renewCh:
+		return superviseRunResult{renewed: true, ran: true}
+	default:
+	}
+
+	if execErr == nil {
+		return superviseRunResult{ran: true}
+	}
+
+	if len(cmds) > 1 {
+		printPipelineErrors(m.Err(), cmds, pipelineRes)
+	} else {
+		fmt.Fprintln(m.Err(), execErr)
+		printStopSignal(m.Err(), pipelineRes.Cmd[cmds[0]])
+	}
+
+	code := PipelineExitCode(cmds, pipelineRes)
+	if code == 0 {
+		code = 1
+	}
+	return superviseRunResult{code: code, ran: true}
+}
+
+// superviseLog writes a [--supervise] decision to standard error.
+func (m *Exec) superviseLog(format string, a ...interface{}) {
+	fmt.Fprintf(m.Err(), "supervise: "+format+"\n", a...)
+}
@@ -0,0 +1,127 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/codeactual/aws-exec-cmd/mixin"
+)
+
+func TestRestartDecisions(t *testing.T) {
+	const short = time.Second
+
+	for _, c := range []struct {
+		desc        string
+		restart     string
+		backoff     int
+		backoffMax  int
+		maxRestarts int
+		runs        []mixin.SupervisedRun
+		expected    []mixin.RestartDecision
+	}{
+		{
+			desc:       "on-failure ends after a successful run",
+			restart:    mixin.RestartOnFailure,
+			backoff:    1,
+			backoffMax: 60,
+			runs:       []mixin.SupervisedRun{{Code: 0, Duration: short}},
+			expected: []mixin.RestartDecision{
+				{Reason: "run ended with code 0; not restarting the command (--restart on-failure)"},
+			},
+		},
+		{
+			desc:       "consecutive failures double the delay",
+			restart:    mixin.RestartOnFailure,
+			backoff:    1,
+			backoffMax: 60,
+			runs:       []mixin.SupervisedRun{{Code: 1, Duration: short}, {Code: 2, Duration: short}, {Code: 1, Duration: short}},
+			expected: []mixin.RestartDecision{
+				{Restart: true, Delay: time.Second, Reason: "run ended with code 1; restarting the command in 1s (restart 1 of unlimited)"},
+				{Restart: true, Delay: 2 * time.Second, Reason: "run ended with code 2; restarting the command in 2s (restart 2 of unlimited)"},
+				{Restart: true, Delay: 4 * time.Second, Reason: "run ended with code 1; restarting the command in 4s (restart 3 of unlimited)"},
+			},
+		},
+		{
+			desc:       "the delay is limited by the maximum",
+			restart:    mixin.RestartOnFailure,
+			backoff:    10,
+			backoffMax: 30,
+			runs:       []mixin.SupervisedRun{{Code: 1, Duration: short}, {Code: 1, Duration: short}, {Code: 1, Duration: short}, {Code: 1, Duration: short}},
+			expected: []mixin.RestartDecision{
+				{Restart: true, Delay: 10 * time.Second, Reason: "run ended with code 1; restarting the command in 10s (restart 1 of unlimited)"},
+				{Restart: true, Delay: 20 * time.Second, Reason: "run ended with code 1; restarting the command in 20s (restart 2 of unlimited)"},
+				{Restart: true, Delay: 30 * time.Second, Reason: "run ended with code 1; restarting the command in 30s (restart 3 of unlimited)"},
+				{Restart: true, Delay: 30 * time.Second, Reason: "run ended with code 1; restarting the command in 30s (restart 4 of unlimited)"},
+			},
+		},
+		{
+			desc:       "a run which lasts the maximum delay resets it",
+			restart:    mixin.RestartOnFailure,
+			backoff:    1,
+			backoffMax: 60,
+			runs:       []mixin.SupervisedRun{{Code: 1, Duration: short}, {Code: 1, Duration: short}, {Code: 1, Duration: time.Minute}},
+			expected: []mixin.RestartDecision{
+				{Restart: true, Delay: time.Second, Reason: "run ended with code 1; restarting the command in 1s (restart 1 of unlimited)"},
+				{Restart: true, Delay: 2 * time.Second, Reason: "run ended with code 1; restarting the command in 2s (restart 2 of unlimited)"},
+				{Restart: true, Delay: time.Second, Reason: "run ended with code 1; restarting the command in 1s (restart 3 of unlimited)"},
+			},
+		},
+		{
+			desc:       "always restarts after a successful run, which resets the delay",
+			restart:    mixin.RestartAlways,
+			backoff:    1,
+			backoffMax: 60,
+			runs:       []mixin.SupervisedRun{{Code: 1, Duration: short}, {Code: 1, Duration: short}, {Code: 0, Duration: short}, {Code: 1, Duration: short}},
+			expected: []mixin.RestartDecision{
+				{Restart: true, Delay: time.Second, Reason: "run ended with code 1; restarting the command in 1s (restart 1 of unlimited)"},
+				{Restart: true, Delay: 2 * time.Second, Reason: "run ended with code 1; restarting the command in 2s (restart 2 of unlimited)"},
+				{Restart: true, Delay: time.Second, Reason: "run ended with code 0; restarting the command in 1s (restart 3 of unlimited)"},
+				{Restart: true, Delay: time.Second, Reason: "run ended with code 1; restarting the command in 1s (restart 4 of unlimited)"},
+			},
+		},
+		{
+			desc:        "max restarts ends the supervision",
+			restart:     mixin.RestartAlways,
+			backoff:     0,
+			backoffMax:  60,
+			maxRestarts: 2,
+			runs:        []mixin.SupervisedRun{{Code: 0, Duration: short}, {Code: 3, Duration: short}, {Code: 4, Duration: short}},
+			expected: []mixin.RestartDecision{
+				{Restart: true, Reason: "run ended with code 0; restarting the command in 0s (restart 1 of 2)"},
+				{Restart: true, Reason: "run ended with code 3; restarting the command in 0s (restart 2 of 2)"},
+				{Reason: "run ended with code 4; not restarting the command after 2 restarts (--max-restarts)"},
+			},
+		},
+		{
+			desc:        "renewals are neither delayed nor counted",
+			restart:     mixin.RestartOnFailure,
+			backoff:     1,
+			backoffMax:  60,
+			maxRestarts: 1,
+			runs:        []mixin.SupervisedRun{{Renewed: true}, {Renewed: true}, {Code: 1, Duration: short}, {Renewed: true}, {Code: 1, Duration: short}},
+			expected: []mixin.RestartDecision{
+				{Restart: true, Reason: "restarting the command with renewed credentials"},
+				{Restart: true, Reason: "restarting the command with renewed credentials"},
+				{Restart: true, Delay: time.Second, Reason: "run ended with code 1; restarting the command in 1s (restart 1 of 1)"},
+				{Restart: true, Reason: "restarting the command with renewed credentials"},
+				{Reason: "run ended with code 1; not restarting the command after 1 restarts (--max-restarts)"},
+			},
+		},
+	} {
+		m := mixin.New()
+		m.Restart = c.restart
+		m.RestartBackoffSec = c.backoff
+		m.RestartBackoffMaxSec = c.backoffMax
+		m.MaxRestarts = c.maxRestarts
+
+		require.Exactly(t, c.expected, m.RestartDecisions(c.runs...), c.desc)
+	}
+}
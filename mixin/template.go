@@ -0,0 +1,126 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package mixin
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/aws/credentials/expiring"
+	cage_sts "github.com/codeactual/aws-exec-cmd/internal/cage/aws/v1/sts"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/mixin/aws/auth"
+)
+
+// templateData holds the fields available to [--template] arguments.
+type templateData struct {
+	// AccountID is the account of the principal, e.g. "123456789012".
+	AccountID string
+
+	// Arn is the principal's ARN, e.g. "arn:aws:sts::123456789012:assumed-role/name/session".
+	Arn string
+
+	// RoleName is empty if the principal is not a role.
+	RoleName string
+
+	// SessionName is empty if the principal is not an assumed-role session.
+	SessionName string
+
+	// Region is the [--region] value.
+	Region string
+
+	// Expiration uses RFC 3339 format in UTC, or is empty if unknown.
+	Expiration string
+}
+
+// parseArgTemplates parses each argument as a template.
+func parseArgTemplates(args []string) ([]*template.Template, error) {
+	tmpls := make([]*template.Template, len(args))
+	for n, arg := range args {
+		tmpl, parseErr := template.New("arg").Option("missingkey=error").Parse(arg)
+		if parseErr != nil {
+			return nil, errors.Wrapf(parseErr, "failed to parse --template argument [%s]", arg)
+		}
+		tmpls[n] = tmpl
+	}
+	return tmpls, nil
+}
+
+// checkArgTemplates returns an error if an argument is not a valid template, e.g. if it
+// refers to an unknown field, so the mistake is reported before credentials are acquired.
+func checkArgTemplates(args []string) error {
+	_, err := expandArgTemplates(args, templateData{})
+	return errors.WithStack(err)
+}
+
+// expandArgTemplates executes each argument as a template with the data.
+func expandArgTemplates(args []string, data templateData) ([]string, error) {
+	tmpls, parseErr := parseArgTemplates(args)
+	if parseErr != nil {
+		return nil, errors.WithStack(parseErr)
+	}
+
+	expanded := make([]string, len(args))
+	for n, tmpl := range tmpls {
+		var buf bytes.Buffer
+		if execErr := tmpl.Execute(&buf, data); execErr != nil {
+			return nil, errors.Wrapf(execErr, "failed to expand --template argument [%s]", args[n])
+		}
+		expanded[n] = buf.String()
+	}
+	return expanded, nil
+}
+
+// newTemplateData returns the details of the credentials, read from the role chain metadata
+// or, if the principal's ARN is unknown, from a GetCallerIdentity call.
+func newTemplateData(a *auth.Mixin, res auth.Result) (templateData, error) {
+	arn := res.Arn
+
+	if arn == "" {
+		identity, identityErr := cage_sts.GetCallerIdentity(res.Creds, a.Region)
+		if identityErr != nil {
+			return templateData{}, errors.Wrap(identityErr, "failed to get caller identity for --template")
+		}
+		arn = aws.StringValue(identity.Arn)
+	}
+
+	pr := newPrincipal(a, arn)
+
+	data := templateData{
+		AccountID:   pr.AccountID,
+		Arn:         pr.Arn,
+		RoleName:    pr.RoleName,
+		SessionName: pr.SessionName,
+		Region:      a.Region,
+	}
+
+	if expires, ok := expiring.ExpiresAt(res.Creds); ok {
+		data.Expiration = expires.UTC().Format(time.RFC3339)
+	}
+
+	return data, nil
+}
+
+// expandStages expands the [--template] arguments of each pipeline command.
+func expandStages(a *auth.Mixin, res auth.Result, stages [][]string) ([][]string, error) {
+	data, dataErr := newTemplateData(a, res)
+	if dataErr != nil {
+		return nil, errors.WithStack(dataErr)
+	}
+
+	expanded := make([][]string, len(stages))
+	for n, stage := range stages {
+		var expandErr error
+		if expanded[n], expandErr = expandArgTemplates(stage, data); expandErr != nil {
+			return nil, errors.WithStack(expandErr)
+		}
+	}
+	return expanded, nil
+}
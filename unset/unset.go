@@ -0,0 +1,74 @@
+// Copyright (C) 2019 The aws-exec-cmd Authors.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package unset
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler"
+	handler_cobra "github.com/codeactual/aws-exec-cmd/internal/cage/cli/handler/cobra"
+	"github.com/codeactual/aws-exec-cmd/internal/cage/os/shell"
+	cage_reflect "github.com/codeactual/aws-exec-cmd/internal/cage/reflect"
+	cmd_mixin "github.com/codeactual/aws-exec-cmd/mixin"
+)
+
+// Handler defines the sub-command flags and logic.
+type Handler struct {
+	handler.Session
+
+	Format string `usage:"Output format: sh, fish, powershell, dotenv, or json"`
+}
+
+// Init defines the command, its environment variable prefix, etc.
+//
+// It implements cli/handler/cobra.Handler.
+func (h *Handler) Init() handler_cobra.Init {
+	return handler_cobra.Init{
+		Cmd: &cobra.Command{
+			Use:   "unset",
+			Short: "Print statements which clear the credentials printed by --print",
+		},
+		EnvPrefix: "AWS_EXEC_CMD",
+	}
+}
+
+// BindFlags binds the flags to Handler fields.
+//
+// It implements cli/handler/cobra.Handler.
+func (h *Handler) BindFlags(cmd *cobra.Command) []string {
+	cmd.Flags().StringVarP(&h.Format, "format", "", shell.FormatSh, cage_reflect.GetFieldTag(*h, "Format", "usage"))
+	return []string{}
+}
+
+// Implements cage/cli/handler.PreRun
+func (h *Handler) PreRun(ctx context.Context, args []string) error {
+	if !shell.IsFormat(h.Format) {
+		return errors.Errorf("--format [%s] must be one of: %s", h.Format, strings.Join(shell.Formats, ", "))
+	}
+	return nil
+}
+
+// Run performs the sub-command logic.
+//
+// It implements cli/handler/cobra.Handler.
+func (h *Handler) Run(ctx context.Context, input handler.Input) {
+	h.ExitOnErr(cmd_mixin.PrintUnset(h.Out(), h.Format), "failed to print statements", 1)
+}
+
+// New returns a cobra command instance based on Handler.
+func NewCommand() *cobra.Command {
+	return handler_cobra.NewHandler(&Handler{
+		Session: &handler.DefaultSession{},
+	})
+}
+
+var _ handler_cobra.Handler = (*Handler)(nil)
+var _ handler.PreRun = (*Handler)(nil)